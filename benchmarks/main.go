@@ -0,0 +1,47 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"regexp"
+	"testing"
+)
+
+// go run ./benchmarks [-run regexp]
+func main() {
+	testing.Init()
+	run := flag.String("run", ".", "run benchmarks matching regexp")
+	flag.Parse()
+
+	filter, err := regexp.Compile(*run)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	benchmarks := []struct {
+		name string
+		fn   func(*testing.B)
+	}{
+		{"YAMLUnmarshal", BenchmarkYAMLUnmarshal},
+		{"JSONUnmarshal", BenchmarkJSONUnmarshal},
+		{"RouterTreeLoad10k", BenchmarkRouterTreeLoad10k},
+		{"RouterTableLoad10k", BenchmarkRouterTableLoad10k},
+		{"RouterTreeLoad100k", BenchmarkRouterTreeLoad100k},
+		{"RouterTableLoad100k", BenchmarkRouterTableLoad100k},
+		{"RouterTreeRoute10k", BenchmarkRouterTreeRoute10k},
+		{"RouterTableRoute10k", BenchmarkRouterTableRoute10k},
+		{"RouterTreeRoute100k", BenchmarkRouterTreeRoute100k},
+		{"RouterTableRoute100k", BenchmarkRouterTableRoute100k},
+		{"MetricsObserve", BenchmarkMetricsObserve},
+		{"SketchQuantile", BenchmarkSketchQuantile},
+	}
+
+	for _, bm := range benchmarks {
+		if !filter.MatchString(bm.name) {
+			continue
+		}
+		result := testing.Benchmark(bm.fn)
+		fmt.Printf("%-24s %s %s\n", bm.name, result.String(), result.MemString())
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"gogogo/middleware/metrics"
+)
+
+// BenchmarkMetricsObserve records requests from all cores at once, spread
+// over 100 routes, the path every instrumented request takes
+func BenchmarkMetricsObserve(b *testing.B) {
+	m := metrics.GetMetrics()
+	routes := make([]string, 100)
+	for i := range routes {
+		routes[i] = fmt.Sprintf("/section/page%d", i)
+	}
+
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			m.Observe(routes[i%len(routes)], 200, time.Duration(i%5000)*time.Microsecond)
+			i++
+		}
+	})
+}
+
+// BenchmarkSketchQuantile merges a sketch and reads its p99, the work done
+// per route when latencies are reported
+func BenchmarkSketchQuantile(b *testing.B) {
+	var sketch metrics.Sketch
+	for i := 0; i < 100000; i++ {
+		sketch.Observe(time.Duration(i) * time.Microsecond)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var sn metrics.Snapshot
+		sn.Add(&sketch)
+		sn.Quantile(.99)
+	}
+}
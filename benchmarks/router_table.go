@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gogogo/modules/router"
+)
+
+// routeSet builds n content routes spread over 100 sections, like a large
+// site after cmd/build
+func routeSet(n int) map[string]router.FileInfo {
+	routes := make(map[string]router.FileInfo, n)
+	for i := 0; i < n; i++ {
+		key := fmt.Sprintf("content/section%d/page%d/content.html", i%100, i)
+		routes[key] = router.FileInfo{
+			ModTime:  time.Unix(1700000000, 0),
+			DistPath: fmt.Sprintf("dist/content/section%d/page%d/content.%08x.html", i%100, i, i),
+		}
+	}
+	return routes
+}
+
+// writeRouters writes both router formats for routes into dir
+func writeRouters(dir string, routes map[string]router.FileInfo) (string, string, error) {
+	root := &router.RadixNode{}
+	for key, info := range routes {
+		info := info
+		root.Insert(splitKey(key), &info)
+	}
+
+	binPath := filepath.Join(dir, "router_binary.bin")
+	tablePath := filepath.Join(dir, "router_table.bin")
+
+	f, err := os.Create(binPath)
+	if err != nil {
+		return "", "", err
+	}
+	err = router.WriteBinary(f, root, "bench", time.Now())
+	f.Close()
+	if err != nil {
+		return "", "", err
+	}
+
+	f, err = os.Create(tablePath)
+	if err != nil {
+		return "", "", err
+	}
+	err = router.WriteTable(f, routes, "bench", time.Now())
+	f.Close()
+	if err != nil {
+		return "", "", err
+	}
+
+	return binPath, tablePath, nil
+}
+
+func splitKey(key string) []string {
+	var segments []string
+	start := 0
+	for i := 0; i <= len(key); i++ {
+		if i == len(key) || key[i] == '/' {
+			segments = append(segments, key[start:i])
+			start = i + 1
+		}
+	}
+	return segments
+}
+
+func benchmarkLoad(b *testing.B, n int, table bool) {
+	dir := b.TempDir()
+	binPath, tablePath, err := writeRouters(dir, routeSet(n))
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var r *router.Router
+		if table {
+			r, err = router.LoadTable(tablePath)
+		} else {
+			r, err = router.LoadFromBinary(binPath)
+		}
+		if err != nil {
+			b.Fatal(err)
+		}
+		r.Close()
+	}
+}
+
+func benchmarkRoute(b *testing.B, n int, table bool) {
+	dir := b.TempDir()
+	binPath, tablePath, err := writeRouters(dir, routeSet(n))
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	var r *router.Router
+	if table {
+		r, err = router.LoadTable(tablePath)
+	} else {
+		r, err = router.LoadFromBinary(binPath)
+	}
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer r.Close()
+
+	paths := make([]string, 1024)
+	for i := range paths {
+		page := (i * 7919) % n
+		paths[i] = fmt.Sprintf("content/section%d/page%d/content.html", page%100, page)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, ok := r.Route(paths[i%len(paths)]); !ok {
+			b.Fatal("route not found")
+		}
+	}
+}
+
+func BenchmarkRouterTreeLoad10k(b *testing.B)   { benchmarkLoad(b, 10_000, false) }
+func BenchmarkRouterTableLoad10k(b *testing.B)  { benchmarkLoad(b, 10_000, true) }
+func BenchmarkRouterTreeLoad100k(b *testing.B)  { benchmarkLoad(b, 100_000, false) }
+func BenchmarkRouterTableLoad100k(b *testing.B) { benchmarkLoad(b, 100_000, true) }
+
+func BenchmarkRouterTreeRoute10k(b *testing.B)   { benchmarkRoute(b, 10_000, false) }
+func BenchmarkRouterTableRoute10k(b *testing.B)  { benchmarkRoute(b, 10_000, true) }
+func BenchmarkRouterTreeRoute100k(b *testing.B)  { benchmarkRoute(b, 100_000, false) }
+func BenchmarkRouterTableRoute100k(b *testing.B) { benchmarkRoute(b, 100_000, true) }
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Build phases, every phase only starts once the previous one finished so
+// references can be rewritten to the fingerprinted files it produced
+const (
+	phaseAssets = iota // images, fonts, scripts and other files
+	phaseStyles        // stylesheets, may reference assets through url()
+	phasePages         // templates and content, may reference both
+	phaseCount
+)
+
+func buildPhase(relPath string) int {
+	switch filepath.Ext(relPath) {
+	case ".css":
+		return phaseStyles
+	case ".html", ".md":
+		return phasePages
+	}
+	return phaseAssets
+}
+
+// assetKey returns the logical URL path of a servable asset: files in the
+// static directory keep their path, page files in the content directory are
+// served below /static/ like the page style and script URLs
+func (ctx *BuildContext) assetKey(relPath string) (string, bool) {
+	relPath = filepath.ToSlash(relPath)
+	if isDynamic(relPath) {
+		return "", false
+	}
+
+	staticDir := ctx.config.Directories.Static + "/"
+	if strings.HasPrefix(relPath, staticDir) {
+		return relPath, true
+	}
+
+	contentDir := ctx.config.Directories.Content + "/"
+	if rest, ok := strings.CutPrefix(relPath, contentDir); ok {
+		switch path.Base(rest) {
+		case "content.html", "content.md", "meta.toml":
+			return "", false
+		}
+		return path.Join(ctx.config.Directories.Static, rest), true
+	}
+
+	return "", false
+}
+
+// assetBase is the logical directory relative url() references in the
+// stylesheet at relPath resolve against
+func (ctx *BuildContext) assetBase(relPath string) string {
+	if key, ok := ctx.assetKey(relPath); ok {
+		return path.Dir(key)
+	}
+	return path.Dir(filepath.ToSlash(relPath))
+}
+
+// registerAsset routes the logical and fingerprinted URL of an asset to its
+// dist file and records the mapping in the manifest
+func (w *Worker) registerAsset(item WorkItem, result ProcessResult) {
+	key, ok := w.ctx.assetKey(item.RelPath)
+	if !ok {
+		return
+	}
+
+	hashedKey := path.Join(path.Dir(key), filepath.Base(result.FileInfo.DistPath))
+	w.route(key, result.FileInfo)
+	w.route(hashedKey, result.FileInfo)
+	w.ctx.assets.Set(key, "/"+hashedKey)
+}
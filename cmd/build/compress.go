@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gogogo/modules/compression"
+	"gogogo/modules/router"
+)
+
+// isTemplate reports whether relPath is a template, which is only read by the
+// server and never sent to clients as is
+func (ctx *BuildContext) isTemplate(relPath string) bool {
+	return strings.HasPrefix(relPath, ctx.config.Directories.Templates+string(filepath.Separator))
+}
+
+// publish describes and precompresses a generated file and routes it at key
+func (w *Worker) publish(key string, info *router.FileInfo, content []byte) error {
+	describe(info, content)
+	if err := w.precompress(info, content); err != nil {
+		return err
+	}
+	w.route(key, *info)
+	return nil
+}
+
+// precompress writes the configured encodings of content next to its dist
+// file and records them in info. Dist names change with their content, so
+// variants left by an earlier build are reused.
+func (w *Worker) precompress(info *router.FileInfo, content []byte) error {
+	cfg := w.ctx.config.Build
+	if len(cfg.Precompress) == 0 || len(content) < cfg.CompressMinSize || !compression.Compressible(info.ContentType) {
+		return nil
+	}
+
+	for _, encoding := range cfg.Precompress {
+		ext := compression.Extension(encoding)
+		if ext == "" {
+			return fmt.Errorf("unsupported precompress encoding %q", encoding)
+		}
+
+		variantPath := info.DistPath + ext
+		var size int64
+		if stat, err := os.Stat(variantPath); err == nil {
+			size = stat.Size()
+		} else {
+			compressed, err := compression.Compress(encoding, content)
+			if err != nil {
+				return fmt.Errorf("%s: %w", encoding, err)
+			}
+			// A variant that saves nothing is not worth serving
+			if len(compressed) >= len(content) {
+				continue
+			}
+			if err := atomicWrite(variantPath, compressed); err != nil {
+				return err
+			}
+			size = int64(len(compressed))
+		}
+
+		if info.Encodings == nil {
+			info.Encodings = make(map[string]int64, len(cfg.Precompress))
+		}
+		info.Encodings[encoding] = size
+	}
+	return nil
+}
+
+// route registers a dist file at key, and its precompressed variants at key
+// plus their extension with an ETag of their own
+func (w *Worker) route(key string, info router.FileInfo) {
+	w.ctx.fileCache.Set(key, info)
+
+	for encoding, size := range info.Encodings {
+		ext := compression.Extension(encoding)
+		w.ctx.fileCache.Set(key+ext, router.FileInfo{
+			ModTime:     info.ModTime,
+			DistPath:    info.DistPath + ext,
+			DependsOn:   info.DependsOn,
+			AliasedPath: info.AliasedPath,
+			Size:        size,
+			ETag:        compression.VariantETag(info.ETag, encoding),
+			ContentType: info.ContentType,
+		})
+	}
+}
@@ -2,18 +2,24 @@ package main
 
 import (
 	"bytes"
-	"encoding/gob"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"runtime"
+	"sort"
 	"strings"
 	"sync/atomic"
 	"time"
 
+	"gogogo/modules/assets"
 	"gogogo/modules/config"
+	"gogogo/modules/fileaccess"
+	"gogogo/modules/filemanager"
 	"gogogo/modules/router"
+	"gogogo/modules/templates"
 
 	"github.com/BurntSushi/toml"
 )
@@ -26,7 +32,10 @@ type BuildContext struct {
 	depGraph    *DependencyGraph
 	bufferPool  *BufferPool
 	workerpool  *WorkerPool
+	phases      [phaseCount][]WorkItem
+	assets      *assets.Manifest
 	minifier    *MinificationWorker
+	templates   *templates.TemplateEngine
 	errors      *ErrorCollector
 	aliasMap    map[string]string
 	usedAliases map[string]string
@@ -70,8 +79,23 @@ func (ctx *BuildContext) initialize() error {
 	ctx.bufferPool = NewBufferPool(defaultBufferSize)
 	ctx.errors = NewErrorCollector()
 
+	// Templates are read straight from the web directory so pre-rendering
+	// resolves layouts exactly like the development server does
+	fm := filemanager.New(fileaccess.New(), nil, nil, filemanager.Config{
+		RootDir: ctx.config.Directories.Web,
+	})
+	ctx.templates = templates.New(fm, ctx.config.Directories.Templates, false)
+
+	// Incremental builds skip unchanged assets, keep their fingerprints
+	ctx.assets = assets.New()
+	manifestPath = filepath.Join(ctx.config.Directories.Meta, "asset_manifest.json")
+	if err := ctx.assets.Load(manifestPath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to load asset manifest: %w", err)
+	}
+	ctx.templates.SetAssets(ctx.assets)
+
 	if ctx.dryRun {
-		log.Println("DRY RUN - no files will be written")
+		slog.Info("Dry run, no files will be written")
 	}
 
 	if ctx.target != "" {
@@ -81,11 +105,11 @@ func (ctx *BuildContext) initialize() error {
 		}
 		// Override toBuildDir with just the target
 		toBuildDir = []string{targetPath}
-		log.Printf("Building target directory: %s", ctx.target)
+		slog.Info("Building target directory", "target", ctx.target)
 	} else {
 		entries, err := os.ReadDir(ctx.config.Directories.Web)
 		if err != nil {
-			log.Fatalf("failed to load read web directory: %w", err)
+			return fmt.Errorf("failed to read web directory: %w", err)
 		}
 
 		for _, entry := range entries {
@@ -103,7 +127,7 @@ func (ctx *BuildContext) initialize() error {
 		ctx.concurrency = runtime.NumCPU()
 	} else if ctx.concurrency > maxWorkers {
 		ctx.concurrency = maxWorkers
-		log.Printf("Concurency set to %d", ctx.concurrency)
+		slog.Warn("Concurrency capped", "workers", ctx.concurrency)
 	}
 
 	// Load caches concurrently
@@ -127,7 +151,7 @@ func (ctx *BuildContext) initialize() error {
 }
 
 func (ctx *BuildContext) build() error {
-	log.Println("Starting build process...")
+	slog.Info("Starting build process")
 
 	// Count total files first
 	for _, dir := range toBuildDir {
@@ -148,11 +172,26 @@ func (ctx *BuildContext) build() error {
 	}
 
 	if !ctx.dryRun {
+		// Assets first, then stylesheets, then pages, so each phase can
+		// rewrite references to what the previous ones fingerprinted
+		for _, items := range ctx.phases {
+			for _, item := range items {
+				ctx.workerpool.Submit(item)
+			}
+			ctx.workerpool.Drain()
+		}
+
 		// Wait for completion
 		if err := ctx.workerpool.Wait(); err != nil {
 			return err
 		}
 
+		// The server reloads on a new router binary, the manifest it reads
+		// along with it has to be in place first
+		if err := ctx.assets.Save(manifestPath); err != nil {
+			return fmt.Errorf("failed to save asset manifest: %w", err)
+		}
+
 		// Build router binary
 		if err := ctx.buildRouterBinary(); err != nil {
 			return err
@@ -284,7 +323,7 @@ func (ctx *BuildContext) processDirectory(dir string) error {
 			aliasedPath := ctx.getAliasedPath(relPath)
 
 			if ctx.dryRun {
-				log.Printf("Would build: %s -> %s", relPath, aliasedPath)
+				slog.Info("Would build", "path", relPath, "aliased", aliasedPath)
 				atomic.AddInt32(&ctx.buildStats.ProcessedFiles, 1)
 				return nil
 			}
@@ -294,7 +333,8 @@ func (ctx *BuildContext) processDirectory(dir string) error {
 				atomic.AddInt64(&ctx.buildStats.TotalSize, info.Size())
 			}
 
-			ctx.workerpool.Submit(WorkItem{
+			phase := buildPhase(relPath)
+			ctx.phases[phase] = append(ctx.phases[phase], WorkItem{
 				Path:        path,
 				RelPath:     relPath,
 				AliasedPath: aliasedPath,
@@ -303,8 +343,7 @@ func (ctx *BuildContext) processDirectory(dir string) error {
 			atomic.AddInt32(&ctx.buildStats.ProcessedFiles, 1)
 
 			if ctx.buildStats.ProcessedFiles%10 == 0 {
-				log.Printf("Progress: %d/%d files processed",
-					ctx.buildStats.ProcessedFiles, ctx.buildStats.TotalFiles)
+				slog.Info("Progress", "processed", ctx.buildStats.ProcessedFiles, "total", ctx.buildStats.TotalFiles)
 			}
 		} else {
 			atomic.AddInt32(&ctx.buildStats.SkippedFiles, 1)
@@ -338,9 +377,15 @@ func (ctx *BuildContext) buildRouterBinary() error {
 	}
 
 	fileInfos := ctx.fileCache.GetAll()
+	routes := make(map[string]router.FileInfo, len(fileInfos))
 	for path, info := range fileInfos {
 		segments := strings.Split(strings.Trim(path, "/"), "/")
+		// [slug] and [...rest] directories become :slug and *rest segments
+		for i, segment := range segments {
+			segments[i], _ = router.DynamicSegment(segment)
+		}
 		root.Insert(segments, &info) // Note: need to make Insert public in RadixNode
+		routes[strings.Join(segments, "/")] = info
 	}
 
 	// Create meta directory if needed
@@ -354,7 +399,17 @@ func (ctx *BuildContext) buildRouterBinary() error {
 	buffer := bytes.NewBuffer(buf)
 	buffer.Grow(1 << 20)
 
-	if err := gob.NewEncoder(buffer).Encode(root); err != nil {
+	id, builtAt := buildID(fileInfos), time.Now()
+	if err := router.WriteBinary(buffer, root, id, builtAt); err != nil {
+		return err
+	}
+
+	// Compiled flat table, served instead when the router format is "table"
+	var table bytes.Buffer
+	if err := router.WriteTable(&table, routes, id, builtAt); err != nil {
+		return err
+	}
+	if err := atomicWrite(filepath.Join(ctx.config.Directories.Meta, "router_table.bin"), table.Bytes()); err != nil {
 		return err
 	}
 
@@ -389,3 +444,20 @@ func (ctx *BuildContext) printBuildStats() {
 		fmt.Printf("Output Directory: %s\n", ctx.outputDir)
 	}
 }
+
+// buildID derives an identifier from the routed dist paths, which embed
+// content hashes, so identical output always gets the same ID
+func buildID(fileInfos map[string]router.FileInfo) string {
+	paths := make([]string, 0, len(fileInfos))
+	for path, info := range fileInfos {
+		paths = append(paths, path+"\x00"+info.DistPath)
+	}
+	sort.Strings(paths)
+
+	h := sha256.New()
+	for _, p := range paths {
+		h.Write([]byte(p))
+		h.Write([]byte{'\n'})
+	}
+	return hex.EncodeToString(h.Sum(nil))[:12]
+}
@@ -3,7 +3,8 @@ package main
 import (
 	"flag"
 	"gogogo/modules/config"
-	"log"
+	"gogogo/modules/logger"
+	"log/slog"
 	"runtime"
 	"time"
 )
@@ -23,6 +24,7 @@ var (
 var (
 	fileInfoPath   string
 	buildCachePath string
+	manifestPath   string
 	toBuildDir     []string
 )
 
@@ -38,15 +40,18 @@ func main() {
 	out := flag.String("out", "", "Custom output directory (default: dist)")
 	flag.Parse()
 
-	// Initialize logger
-	log.SetFlags(0)
-	if *verbose {
-		log.SetFlags(log.Ltime | log.Lmicroseconds)
-	}
-
 	cfg, err := config.LoadConfig("web/config.toml")
 	if err != nil {
-		log.Fatalf("Failed to load config: %v", err)
+		logger.Fatal("Failed to load config", "error", err)
+	}
+
+	// Build output goes to the terminal only, -v adds debug messages
+	level := cfg.Logging.Level
+	if *verbose {
+		level = "debug"
+	}
+	if _, err := logger.Setup(logger.Config{Level: level, Format: cfg.Logging.Format}); err != nil {
+		logger.Fatal("Failed to set up logging", "error", err)
 	}
 
 	// Initialize build context
@@ -64,22 +69,22 @@ func main() {
 
 	// Run build or watch
 	if *watch {
-		log.Println("Starting watch mode...")
+		slog.Info("Starting watch mode")
 		if err := ctx.watchFiles(); err != nil {
-			log.Fatalf("Watch failed: %v", err)
+			logger.Fatal("Watch failed", "error", err)
 		}
 	} else {
 		if err := ctx.build(); err != nil {
-			log.Fatalf("Build failed: %v", err)
+			logger.Fatal("Build failed", "error", err)
 		}
-		log.Printf("Build completed in %v", time.Since(buildStartTime))
+		slog.Info("Build completed", "duration", time.Since(buildStartTime))
 	}
 
 	// Handle tree command
 	if *treeFlag {
 		treeCmd := NewTreeCommand()
 		if err := treeCmd.Execute(cfg.Directories.Web); err != nil {
-			log.Fatalf("Failed to display tree: %v", err)
+			logger.Fatal("Failed to display tree", "error", err)
 		}
 		return
 	}
@@ -9,6 +9,10 @@ import (
 	"github.com/tdewolff/minify/v2/js"
 )
 
+// templateMimeType minifies HTML while leaving Go template actions untouched,
+// quoted arguments inside attribute values would otherwise be mangled
+const templateMimeType = "text/x-go-template"
+
 type MinificationWorker struct {
 	minifier *minify.M
 	buffers  *sync.Pool
@@ -18,6 +22,7 @@ func NewMinificationWorker() *MinificationWorker {
 	m := minify.New()
 	m.AddFunc("text/css", css.Minify)
 	m.AddFunc("text/html", html.Minify)
+	m.Add(templateMimeType, &html.Minifier{TemplateDelims: html.GoTemplateDelims})
 	m.AddFunc("text/javascript", js.Minify)
 	// m.AddFunc("application/javascript", js.Minify)
 
@@ -3,15 +3,22 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"crypto/md5"
 	"encoding/hex"
+	"encoding/json"
 	"html/template"
 
 	"fmt"
+	"gogogo/modules/markdown"
 	"gogogo/modules/metaparser"
+	"gogogo/modules/pages"
 	"gogogo/modules/router"
+	"mime"
+	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 
 	"github.com/evanw/esbuild/pkg/api"
@@ -19,7 +26,11 @@ import (
 
 type ProcessResult struct {
 	FileInfo     router.FileInfo
+	Page         *router.FileInfo // Pre-rendered page of a content file
+	SPA          *router.FileInfo // Pre-built SPA payload of a content file
 	Content      []byte
+	PageContent  []byte
+	SPAContent   []byte
 	Hash         string
 	Dependencies []string
 }
@@ -42,7 +53,20 @@ func (w *Worker) processFile(item WorkItem) (ProcessResult, error) {
 	hash := md5.Sum(content)
 	hashString := hex.EncodeToString(hash[:])
 
-	if entry, ok := w.ctx.buildCache.Get(item.RelPath); ok && entry.Hash == hashString {
+	// Pages also depend on meta.toml and templates, always render them
+	switch filepath.Base(item.Path) {
+	case "content.html":
+		return w.processContentHTML(item, content, hashString, nil)
+	case "content.md":
+		return w.processContentMarkdown(item, content, hashString)
+	}
+
+	// Stylesheets and HTML embed fingerprints of other files, which may have
+	// changed even when their own source did not
+	ext := filepath.Ext(item.Path)
+	rewrites := ext == ".css" || ext == ".html"
+
+	if entry, ok := w.ctx.buildCache.Get(item.RelPath); ok && entry.Hash == hashString && !rewrites {
 		return ProcessResult{
 			FileInfo: router.FileInfo{
 				ModTime:   item.Info.ModTime(),
@@ -55,15 +79,20 @@ func (w *Worker) processFile(item WorkItem) (ProcessResult, error) {
 		}, nil
 	}
 
-	if filepath.Base(item.Path) == "content.html" {
-		return w.processContentHTML(item, content, hashString)
+	switch ext {
+	case ".css":
+		content = w.ctx.assets.RewriteCSS(content, w.ctx.assetBase(item.RelPath))
+	case ".html":
+		content = w.ctx.assets.RewriteHTML(content)
 	}
 
-	ext := filepath.Ext(item.Path)
 	var mimeType string
 	switch ext {
 	case ".html":
 		mimeType = "text/html"
+		if w.ctx.isTemplate(item.RelPath) {
+			mimeType = templateMimeType
+		}
 	case ".css":
 		mimeType = "text/css"
 	case ".js":
@@ -146,8 +175,27 @@ func extractDependency(line string) string {
 	return ""
 }
 
-// processContentHTML handles content.html files by pre-rendering complete HTML pages
-func (w *Worker) processContentHTML(item WorkItem, content []byte, hashString string) (ProcessResult, error) {
+// processContentMarkdown renders content.md to HTML and pre-renders it like a
+// content.html page, with front matter layered over meta.toml
+func (w *Worker) processContentMarkdown(item WorkItem, content []byte, hashString string) (ProcessResult, error) {
+	htmlPath := filepath.Join(filepath.Dir(item.Path), "content.html")
+	if _, err := os.Stat(htmlPath); err == nil {
+		return ProcessResult{}, fmt.Errorf("both content.html and content.md exist in %s", filepath.Dir(item.Path))
+	}
+
+	rendered, front, err := markdown.Render(content)
+	if err != nil {
+		return ProcessResult{}, err
+	}
+
+	return w.processContentHTML(item, rendered, hashString, front)
+}
+
+// processContentHTML writes the minified content fragment, used by live
+// rendering, and pre-renders the complete HTML page and SPA payload next to
+// it. Pages below [slug] directories only get the fragment, their template
+// data depends on the request.
+func (w *Worker) processContentHTML(item WorkItem, content []byte, hashString string, front *metaparser.MetaData) (ProcessResult, error) {
 	// Determine relative page path for URL formation
 	contentRoot := filepath.Join(w.ctx.config.Directories.Web, w.ctx.config.Directories.Content)
 	parentDir := filepath.Dir(item.Path)
@@ -171,7 +219,7 @@ func (w *Worker) processContentHTML(item WorkItem, content []byte, hashString st
 		meta         *metaparser.MetaData
 		err          error
 	}{
-		content: content,
+		content: w.ctx.assets.RewriteHTML(content),
 		meta:    &metaparser.MetaData{},
 	}
 
@@ -182,16 +230,19 @@ func (w *Worker) processContentHTML(item WorkItem, content []byte, hashString st
 			pd.meta = meta
 		}
 	}
+	if front != nil {
+		pd.meta = pd.meta.Merge(front)
+	}
 
 	// Process style
 	if pd.meta.InlineStyle {
 		style, err := os.ReadFile(stylePath)
 		if err == nil {
-			pd.style = style
+			pd.style = w.ctx.assets.RewriteCSS(style, w.ctx.assetBase(filepath.Join(filepath.Dir(item.RelPath), "style.css")))
 		}
 	} else if _, err := os.Stat(stylePath); err == nil {
 		// Create style URL relative to static path
-		pd.styleExists = fmt.Sprintf("/static/%s/style.css", pagePath)
+		pd.styleExists = w.assetURL(fmt.Sprintf("/static/%s/style.css", pagePath))
 	}
 
 	// Process script
@@ -202,26 +253,43 @@ func (w *Worker) processContentHTML(item WorkItem, content []byte, hashString st
 		}
 	} else if _, err := os.Stat(scriptPath); err == nil {
 		// Create script URL relative to static path
-		pd.scriptExists = fmt.Sprintf("/static/%s/script.js", pagePath)
+		pd.scriptExists = w.assetURL(fmt.Sprintf("/static/%s/script.js", pagePath))
 	}
 
-	// Load main template
-	templatePath := filepath.Join(
-		w.ctx.config.Directories.Web,
-		w.ctx.config.Directories.Templates,
-		w.ctx.config.Templates.Main,
-		"index.html",
-	)
+	relDir := filepath.Dir(item.RelPath)
 
-	templateContent, err := os.ReadFile(templatePath)
+	fragment, err := w.ctx.minifier.Bytes("text/html", pd.content)
+	if err != nil {
+		return ProcessResult{}, fmt.Errorf("error minifying HTML: %w", err)
+	}
+	fragmentPath, err := writeHashed(filepath.Join(w.ctx.outputDir, relDir), "content", ".html", fragment)
 	if err != nil {
-		return ProcessResult{}, fmt.Errorf("error loading template: %w", err)
+		return ProcessResult{}, fmt.Errorf("error writing HTML file: %w", err)
+	}
+
+	result := ProcessResult{
+		FileInfo: router.FileInfo{
+			ModTime:   item.Info.ModTime(),
+			DistPath:  fragmentPath,
+			DependsOn: []string{}, // Pre-rendered HTML doesn't need dependencies
+		},
+		Content:      fragment,
+		Hash:         hashString,
+		Dependencies: []string{},
+	}
+	if isDynamic(item.RelPath) {
+		return result, nil
 	}
 
-	// Parse template
-	tmpl, err := template.New(filepath.Base(templatePath)).Parse(string(templateContent))
+	// Resolve the page template, falling back to the main template
+	templateName := pd.meta.Template
+	if templateName == "" {
+		templateName = w.ctx.config.Templates.Main
+	}
+
+	tmpl, err := w.ctx.templates.GetTemplate(context.Background(), templateName)
 	if err != nil {
-		return ProcessResult{}, fmt.Errorf("error parsing template: %w", err)
+		return ProcessResult{}, fmt.Errorf("error loading template for page %q: %w", pagePath, err)
 	}
 
 	// Prepare template data struct
@@ -232,6 +300,7 @@ func (w *Worker) processContentHTML(item WorkItem, content []byte, hashString st
 		StyleURL  string
 		ScriptURL string
 		Meta      *metaparser.MetaData
+		Params    router.Params
 		IsSPAMode bool
 	}{
 		Content:   template.HTML(pd.content),
@@ -240,7 +309,8 @@ func (w *Worker) processContentHTML(item WorkItem, content []byte, hashString st
 		StyleURL:  pd.styleExists,
 		ScriptURL: pd.scriptExists,
 		Meta:      pd.meta,
-		IsSPAMode: false, // Always false for pre-rendered HTML
+		Params:    nil, // Dynamic segments are only known per request
+		IsSPAMode: w.ctx.config.Server.SPAMode,
 	}
 
 	// Execute template
@@ -250,37 +320,94 @@ func (w *Worker) processContentHTML(item WorkItem, content []byte, hashString st
 	}
 
 	// Minify the resulting HTML
-	renderedHTML := buf.Bytes()
-	minified, err := w.ctx.minifier.Bytes("text/html", renderedHTML)
+	page, err := w.ctx.minifier.Bytes("text/html", w.ctx.assets.RewriteHTML(buf.Bytes()))
 	if err != nil {
 		return ProcessResult{}, fmt.Errorf("error minifying HTML: %w", err)
 	}
 
-	ext := filepath.Ext(item.Path)
-	minifiedHash := md5.Sum(minified)
-	fileName := fmt.Sprintf("%s.%s%s",
-		strings.TrimSuffix(filepath.Base(item.Path), ext),
-		hex.EncodeToString(minifiedHash[:])[:8],
-		ext,
-	)
+	// Write the pre-rendered HTML file, served as is in production
+	pagePathOut, err := writeHashed(filepath.Join(w.ctx.outputDir, relDir), "page", ".html", page)
+	if err != nil {
+		return ProcessResult{}, fmt.Errorf("error writing HTML file: %w", err)
+	}
 
-	relDir := filepath.Dir(item.RelPath)
-	outPath := filepath.Join(w.ctx.outputDir, relDir, fileName)
+	result.Page = &router.FileInfo{
+		ModTime:   item.Info.ModTime(),
+		DistPath:  pagePathOut,
+		DependsOn: []string{},
+	}
+	result.PageContent = page
+
+	// The SPA payload is what the SPA endpoint would encode for this page,
+	// error pages also tell the client which error they stand for
+	var spaError *pages.SPAError
+	if filepath.Dir(pagePath) == pages.ErrorsDir {
+		if status, err := strconv.Atoi(filepath.Base(pagePath)); err == nil {
+			spaError = &pages.SPAError{Status: status, Message: http.StatusText(status)}
+		}
+	}
 
-	// Write the pre-rendered HTML file
-	if err := atomicWrite(outPath, minified); err != nil {
-		return ProcessResult{}, fmt.Errorf("error writing HTML file: %w", err)
+	var payload bytes.Buffer
+	encoder := json.NewEncoder(&payload)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(pages.SPAResponse{
+		Content:   string(fragment),
+		Style:     string(pd.style),
+		Script:    string(pd.script),
+		StyleURL:  pd.styleExists,
+		ScriptURL: pd.scriptExists,
+		Meta:      pd.meta,
+		Error:     spaError,
+		IsSPAMode: w.ctx.config.Server.SPAMode,
+	}); err != nil {
+		return ProcessResult{}, fmt.Errorf("error encoding SPA payload: %w", err)
 	}
 
-	// Regular return for the router
-	return ProcessResult{
-		FileInfo: router.FileInfo{
-			ModTime:   item.Info.ModTime(),
-			DistPath:  outPath,
-			DependsOn: []string{}, // Pre-rendered HTML doesn't need dependencies
-		},
-		Content:      minified,
-		Hash:         hashString,
-		Dependencies: []string{},
-	}, nil
+	spaOut, err := writeHashed(filepath.Join(w.ctx.outputDir, relDir), "spa", ".json", payload.Bytes())
+	if err != nil {
+		return ProcessResult{}, fmt.Errorf("error writing SPA payload: %w", err)
+	}
+
+	result.SPA = &router.FileInfo{
+		ModTime:   item.Info.ModTime(),
+		DistPath:  spaOut,
+		DependsOn: []string{},
+	}
+	result.SPAContent = payload.Bytes()
+
+	return result, nil
+}
+
+// writeHashed writes content to dir as name.<hash>ext and returns its path
+func writeHashed(dir, name, ext string, content []byte) (string, error) {
+	sum := md5.Sum(content)
+	outPath := filepath.Join(dir, fmt.Sprintf("%s.%s%s", name, hex.EncodeToString(sum[:])[:8], ext))
+	return outPath, atomicWrite(outPath, content)
+}
+
+// describe records what the server needs to answer without touching the
+// file: its size, a strong ETag and the content type
+func describe(info *router.FileInfo, content []byte) {
+	sum := md5.Sum(content)
+	info.Size = int64(len(content))
+	info.ETag = `"` + hex.EncodeToString(sum[:]) + `"`
+	info.ContentType = mime.TypeByExtension(filepath.Ext(info.DistPath))
+}
+
+// isDynamic reports whether relPath lies below a [slug] or [...rest] directory
+func isDynamic(relPath string) bool {
+	for _, segment := range strings.Split(filepath.ToSlash(filepath.Dir(relPath)), "/") {
+		if _, ok := router.DynamicSegment(segment); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// assetURL returns the fingerprinted URL for url if the manifest has one
+func (w *Worker) assetURL(url string) string {
+	if hashed, ok := w.ctx.assets.Lookup(strings.TrimPrefix(filepath.ToSlash(filepath.Clean(url)), "/")); ok {
+		return hashed
+	}
+	return url
 }
@@ -2,7 +2,7 @@ package main
 
 import (
     "fmt"
-    "log"
+    "log/slog"
     "os"
     "path/filepath"
     "sync"
@@ -47,7 +47,7 @@ func (ctx *BuildContext) watchFiles() error {
         }
     }
 
-    log.Println("Watching for file changes...")
+    slog.Info("Watching for file changes")
 
     // Process events
     go w.handleEvents()
@@ -67,7 +67,7 @@ func (ctx *BuildContext) watchFiles() error {
             if !ok {
                 return nil
             }
-            log.Printf("Watcher error: %v", err)
+            slog.Error("Watcher error", "error", err)
         }
     }
 }
@@ -88,7 +88,7 @@ func (w *Watcher) debounceEvent(path string) {
 func (w *Watcher) handleEvents() {
     for path := range w.events {
         if err := w.processChange(path); err != nil {
-            log.Printf("Error processing change for %s: %v", path, err)
+            slog.Error("Error processing change", "path", path, "error", err)
         }
     }
 }
@@ -120,7 +120,7 @@ func (w *Watcher) processChange(path string) error {
         return nil
     }
 
-    log.Printf("Processing changed file: %s", relPath)
+    slog.Info("Processing changed file", "path", relPath)
 
     // Process the file
     w.ctx.workerpool.Submit(WorkItem{
@@ -131,7 +131,7 @@ func (w *Watcher) processChange(path string) error {
 
     // Find and process dependents
     if deps := w.ctx.depGraph.GetDependents(relPath); len(deps) > 0 {
-        log.Printf("Processing %d dependents of %s", len(deps), relPath)
+        slog.Info("Processing dependents", "path", relPath, "count", len(deps))
         for _, dep := range deps {
             depPath := filepath.Join(w.ctx.config.Directories.Web, dep)
             depInfo, err := os.Stat(depPath)
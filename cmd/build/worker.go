@@ -3,8 +3,11 @@ package main
 import (
 	"fmt"
 	"os"
+	"path/filepath"
 	"sync"
 	"sync/atomic"
+
+	"gogogo/modules/pages"
 )
 
 type WorkerPool struct {
@@ -53,10 +56,15 @@ func (wp *WorkerPool) Submit(item WorkItem) {
 	wp.workChan <- item
 }
 
-func (wp *WorkerPool) Wait() error {
+// Drain waits for every submitted item without stopping the workers
+func (wp *WorkerPool) Drain() {
 	for atomic.LoadInt32(&wp.activeJobs) > 0 {
 		wp.wg.Wait()
 	}
+}
+
+func (wp *WorkerPool) Wait() error {
+	wp.Drain()
 	close(wp.workChan)
 
 	return wp.ctx.errors.Error()
@@ -85,8 +93,27 @@ func (w *Worker) process(item WorkItem) error {
 
 	if !w.ctx.dryRun {
 		// Only write files and update caches in non-dry-run mode
-		w.ctx.fileCache.Set(item.AliasedPath, result.FileInfo)
-		w.ctx.buildCache.Set(item.AliasedPath, BuildCacheEntry{
+		key := routeKey(item.AliasedPath)
+		describe(&result.FileInfo, result.Content)
+		if !w.ctx.isTemplate(item.RelPath) {
+			if err := w.precompress(&result.FileInfo, result.Content); err != nil {
+				return fmt.Errorf("error compressing %s: %w", item.Path, err)
+			}
+		}
+		w.route(key, result.FileInfo)
+
+		if result.Page != nil {
+			if err := w.publish(filepath.Join(filepath.Dir(key), pages.PageFile), result.Page, result.PageContent); err != nil {
+				return fmt.Errorf("error compressing page of %s: %w", item.Path, err)
+			}
+		}
+		if result.SPA != nil {
+			if err := w.publish(filepath.Join(filepath.Dir(key), pages.SPAFile), result.SPA, result.SPAContent); err != nil {
+				return fmt.Errorf("error compressing SPA payload of %s: %w", item.Path, err)
+			}
+		}
+		w.registerAsset(item, result)
+		w.ctx.buildCache.Set(key, BuildCacheEntry{
 			Content:  result.Content,
 			Hash:     result.Hash,
 			DistPath: result.FileInfo.DistPath,
@@ -101,3 +128,12 @@ func (w *Worker) process(item WorkItem) error {
 
 	return nil
 }
+
+// routeKey maps a source path to the path the server requests it by. Markdown
+// pages are rendered to HTML and served as content.html.
+func routeKey(aliasedPath string) string {
+	if filepath.Base(aliasedPath) == "content.md" {
+		return filepath.Join(filepath.Dir(aliasedPath), "content.html")
+	}
+	return aliasedPath
+}
@@ -2,7 +2,8 @@ package main
 
 import (
 	"context"
-	"log"
+	"errors"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
@@ -11,15 +12,19 @@ import (
 	"syscall"
 	"time"
 
+	"gogogo/middleware/metrics"
+	"gogogo/modules/assets"
 	"gogogo/modules/cache"
 	"gogogo/modules/coalescer"
 	"gogogo/modules/config"
 	"gogogo/modules/fileaccess"
 	"gogogo/modules/filemanager"
 	"gogogo/modules/handlers"
+	"gogogo/modules/logger"
 	"gogogo/modules/router"
 	"gogogo/modules/server"
 	"gogogo/modules/templates"
+	"gogogo/modules/tracing"
 )
 
 func main() {
@@ -27,7 +32,39 @@ func main() {
 
 	cfg, err := config.LoadConfig("web/config.toml")
 	if err != nil {
-		log.Fatalf("Failed to load config: %v", err)
+		logger.Fatal("Failed to load config", "error", err)
+	}
+
+	logs, err := logger.Setup(logger.Config{
+		Level:       cfg.Logging.Level,
+		Format:      cfg.Logging.Format,
+		File:        cfg.Logging.File,
+		Console:     cfg.Logging.Console,
+		MaxSize:     cfg.Logging.MaxSize,
+		RotateEvery: cfg.Logging.RotateEvery,
+		MaxBackups:  cfg.Logging.MaxBackups,
+		MaxAge:      cfg.Logging.MaxAge,
+	})
+	if err != nil {
+		logger.Fatal("Failed to set up logging", "error", err)
+	}
+	defer logs.Close()
+
+	// Spans are no-ops until a provider is set up
+	var tracer *tracing.Provider
+	if cfg.Tracing.Enabled {
+		tracer, err = tracing.Setup(context.Background(), tracing.Config{
+			Exporter:    cfg.Tracing.Exporter,
+			Endpoint:    cfg.Tracing.Endpoint,
+			Insecure:    cfg.Tracing.Insecure,
+			Headers:     cfg.Tracing.Headers,
+			File:        cfg.Tracing.File,
+			SampleRatio: cfg.Tracing.SampleRatio,
+			ServiceName: cfg.Tracing.ServiceName,
+		})
+		if err != nil {
+			slog.Warn("Tracing disabled", "error", err)
+		}
 	}
 
 	// Initialize base layers
@@ -45,11 +82,18 @@ func main() {
 
 	// Load router in production mode
 	var r *router.Router
+	routerPath := filepath.Join(cfg.Directories.Meta, "router_binary.bin")
 	if cfg.Server.ProductionMode {
-		r, err = router.LoadFromBinary(filepath.Join(cfg.Directories.Meta, "router_binary.bin"))
+		if cfg.Router.Format == "table" {
+			routerPath = filepath.Join(cfg.Directories.Meta, "router_table.bin")
+			r, err = router.LoadTable(routerPath)
+		} else {
+			r, err = router.LoadFromBinary(routerPath)
+		}
 		if err != nil {
-			log.Fatalf("Failed to load router: %v", err)
+			logger.Fatal("Failed to load router", "error", err)
 		}
+		slog.Info("Serving build", "build", r.BuildID(), "built_at", r.BuiltAt().Format(time.RFC3339))
 	}
 
 	// Initialize file manager
@@ -60,29 +104,159 @@ func main() {
 
 	templateEngine := templates.New(fm, cfg.Directories.Templates, cfg.Server.ProductionMode)
 
-	// Get main template
-	mainTemplate, err := templateEngine.GetTemplate(filepath.Join(cfg.Templates.Main))
-	if err != nil {
-		log.Fatalf("Failed to load main template: %v", err)
+	// Re-parse templates and reload browsers on change while developing
+	var liveReloadHandler http.Handler
+	if !cfg.Server.ProductionMode {
+		liveReload := server.NewLiveReload()
+		if err := liveReload.Watch(cfg.Directories.Web, cfg.Directories.Content, cfg.Directories.Static); err != nil {
+			slog.Warn("Live reload disabled", "error", err)
+		}
+		defer liveReload.Close()
+		liveReloadHandler = liveReload
+
+		// Templates notify once re-parsed so browsers never reload into a stale layout
+		templateEngine.OnReload = liveReload.Reload
+		templateDir := filepath.Join(cfg.Directories.Web, cfg.Directories.Templates)
+		if err := templateEngine.Watch(templateDir); err != nil {
+			slog.Warn("Template hot reload disabled", "error", err)
+		}
+		defer templateEngine.Close()
+	}
+
+	// Templates link the fingerprinted assets of the build being served. In
+	// development only hashed files present in the web directory are linked,
+	// and without a build there is no manifest to expect.
+	manifest := assets.New()
+	manifestPath := filepath.Join(cfg.Directories.Meta, "asset_manifest.json")
+	if err := manifest.Load(manifestPath); err != nil {
+		if cfg.Server.ProductionMode {
+			slog.Warn("Asset manifest not loaded, assets are linked unhashed", "error", err)
+		} else {
+			slog.Debug("Asset manifest not loaded, assets are linked unhashed", "error", err)
+		}
+	}
+	templateEngine.SetAssets(manifest)
+
+	// Swap in new builds without a restart, on change of the router binary
+	// or SIGHUP, dropping cached files and templates of the old build
+	if cfg.Server.ProductionMode {
+		r.OnReload = func(stale []string) {
+			if cacheInstance != nil {
+				for _, distPath := range stale {
+					cacheInstance.Delete(distPath)
+				}
+			}
+			if err := manifest.Load(manifestPath); err != nil {
+				slog.Error("Asset manifest reload failed", "error", err)
+			}
+			templateEngine.Reset()
+		}
+		if err := r.Watch(routerPath); err != nil {
+			slog.Warn("Router hot reload disabled", "error", err)
+		}
+		defer r.Close()
+
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		go func() {
+			for range hup {
+				stale, err := r.Reload(routerPath)
+				if err != nil {
+					slog.Error("Router reload failed, keeping current routes", "error", err)
+					continue
+				}
+				slog.Info("Router reloaded", "build", r.BuildID(), "stale_files", len(stale))
+				r.OnReload(stale)
+			}
+		}()
+	}
+
+	// Validate main template, pages without a template fall back to it
+	if _, err := templateEngine.GetTemplate(context.Background(), cfg.Templates.Main); err != nil {
+		logger.Fatal("Failed to load main template", "error", err)
+	}
+
+	// Cache policies only apply to production builds, development responses
+	// must never be kept
+	var cacheControl *handlers.CacheControl
+	if cfg.Server.ProductionMode {
+		cacheControl = handlers.NewCacheControl(cfg.CacheControl)
 	}
 
 	// Initialize all handlers
-	webHandler := handlers.NewWebHandler(fm, mainTemplate, cfg.Directories.Content, cfg.Server.SPAMode)
-	staticHandler := handlers.NewStaticHandler(fm)
+	errorPages := handlers.NewErrorPages(fm, templateEngine, cfg.Templates.Main, cfg.Directories.Content, cfg.URLPrefixes.SPA, cfg.Server.SPAMode, cfg.Server.ProductionMode)
+	webHandler := handlers.NewWebHandler(fm, templateEngine, cfg.Templates.Main, cfg.Directories.Content, cacheControl, errorPages, cfg.Server.SPAMode, cfg.Server.ProductionMode)
+	staticHandler := handlers.NewStaticHandler(fm, cacheControl, errorPages)
 	apiHandler := handlers.NewAPIHandler(fm, cfg.Directories.Content)
 
 	// SPA handler only if SPA mode enabled
 	var spaHandler http.Handler
 	if cfg.Server.SPAMode {
-		spaHandler = handlers.NewSPAHandler(fm, cfg.Directories.Content, cfg.Server.SPAMode)
+		spaHandler = handlers.NewSPAHandler(fm, cfg.Directories.Content, cacheControl, errorPages, cfg.Server.SPAMode, cfg.Server.ProductionMode)
 	}
 
-	srv := server.New(server.Handlers{
-		Web:    webHandler,
-		SPA:    spaHandler,
-		Static: staticHandler,
-		API:    apiHandler,
+	srv, err := server.New(server.Handlers{
+		Web:        webHandler,
+		SPA:        spaHandler,
+		Static:     staticHandler,
+		API:        apiHandler,
+		LiveReload: liveReloadHandler,
+		Error:      errorPages.ServeError,
 	}, cfg)
+	if err != nil {
+		logger.Fatal("Failed to set up server", "error", err)
+	}
+
+	// Server stats are sampled on an interval, latencies kept over the
+	// retention period
+	if cfg.Server.MetricsEnabled {
+		m := metrics.GetMetrics()
+		if cacheInstance != nil {
+			m.WatchCache(cacheInstance)
+		}
+		m.Start(cfg.Metrics.CollectionInterval, cfg.Metrics.RetentionPeriod)
+	}
+
+	// Operational endpoints, served apart from the site
+	var admin *server.Admin
+	if cfg.Admin.Enabled {
+		admin = server.NewAdmin(cfg.Admin.Address, cfg.Admin.Socket)
+		if cfg.Admin.Password != "" {
+			admin.RequireAuth(cfg.Admin.Username, cfg.Admin.Password)
+		}
+		if cfg.Server.MetricsEnabled {
+			registry := metrics.NewRegistry()
+			registry.Register(metrics.GetMetrics().WritePrometheus)
+			if cacheInstance != nil {
+				registry.Register(metrics.CacheCollector(cacheInstance))
+			}
+			if coalescerInstance != nil {
+				registry.Register(metrics.CoalescerCollector(coalescerInstance))
+			}
+			if r != nil {
+				registry.Register(metrics.RouterCollector(r))
+			}
+			registry.Register(metrics.RuntimeCollector())
+
+			admin.Mux().Handle("/metrics", registry)
+			metrics.SetupMetricsAPI(admin.Mux())
+
+			// Browsers reach the dashboard over the address, never serve it
+			// there unprotected
+			if cfg.Admin.Password != "" {
+				metrics.SetupDashboard(admin.Mux())
+			} else {
+				slog.Warn("Metrics dashboard disabled, no admin password set")
+			}
+		}
+
+		go func() {
+			slog.Info("Admin listener starting", "address", admin.Addr())
+			if err := admin.Start(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				slog.Error("Admin listener error", "error", err)
+			}
+		}()
+	}
 
 	// Handle shutdown
 	done := make(chan bool, 1)
@@ -91,21 +265,32 @@ func main() {
 
 	go func() {
 		<-quit
-		log.Println("Server is shutting down...")
+		slog.Info("Server is shutting down")
 
 		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 		defer cancel()
 
 		if err := srv.Shutdown(ctx); err != nil {
-			log.Printf("Server shutdown error: %v\n", err)
+			slog.Error("Server shutdown error", "error", err)
+		}
+		if admin != nil {
+			if err := admin.Shutdown(ctx); err != nil {
+				slog.Error("Admin listener shutdown error", "error", err)
+			}
+		}
+		// Last, so spans of the requests drained above are exported
+		if tracer != nil {
+			if err := tracer.Shutdown(ctx); err != nil {
+				slog.Error("Tracing shutdown error", "error", err)
+			}
 		}
 
 		close(done)
 	}()
 
-	log.Printf("Server starting on %s:%d\n", cfg.Server.Host, cfg.Server.Port)
-	if err := srv.Start(); err != nil {
-		log.Printf("Server error: %v\n", err)
+	slog.Info("Server starting", "host", cfg.Server.Host, "port", cfg.Server.Port)
+	if err := srv.Start(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		logger.Fatal("Server error", "error", err)
 	}
 
 	<-done
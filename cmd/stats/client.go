@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"gogogo/middleware/metrics"
+)
+
+const (
+	minRetry = 500 * time.Millisecond
+	maxRetry = 10 * time.Second
+
+	// Route tables of large sites make for long events
+	maxEventSize = 8 << 20
+)
+
+// Client follows the metrics stream of a running server, over its admin
+// address or Unix socket
+type Client struct {
+	url  string
+	http *http.Client
+
+	// Basic authentication, unused without a password
+	username string
+	password string
+}
+
+func NewClient(addr string, socket string, username string, password string) *Client {
+	c := &Client{
+		url:      strings.TrimSuffix(addr, "/") + metrics.StreamPath,
+		http:     &http.Client{},
+		username: username,
+		password: password,
+	}
+
+	if socket != "" {
+		// The host is ignored, every request goes to the socket
+		c.url = "http://admin" + metrics.StreamPath
+		c.http.Transport = &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", socket)
+			},
+		}
+	}
+	return c
+}
+
+// Follow sends every report the server streams to reports until ctx is
+// done, reconnecting with backoff whenever the server goes away. Connection
+// changes are reported on status.
+func (c *Client) Follow(ctx context.Context, reports chan<- metrics.Report, status chan<- string) {
+	retry := minRetry
+	for {
+		connected, err := c.stream(ctx, reports, status)
+		if ctx.Err() != nil {
+			return
+		}
+		if connected {
+			retry = minRetry
+		}
+
+		send(status, fmt.Sprintf("disconnected: %v, retrying in %v", err, retry))
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(retry):
+		}
+		retry = min(retry*2, maxRetry)
+	}
+}
+
+// stream reads one connection until it fails, reporting whether it got as
+// far as receiving events
+func (c *Client) stream(ctx context.Context, reports chan<- metrics.Report, status chan<- string) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url, nil)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	if c.password != "" {
+		req.SetBasicAuth(c.username, c.password)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	send(status, "connected to "+c.url)
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), maxEventSize)
+
+	var event, data string
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "":
+			if event == "metrics" && data != "" {
+				var report metrics.Report
+				if err := json.Unmarshal([]byte(data), &report); err != nil {
+					return true, fmt.Errorf("bad metrics event: %w", err)
+				}
+				select {
+				case reports <- report:
+				case <-ctx.Done():
+					return true, ctx.Err()
+				}
+			}
+			event, data = "", ""
+		case strings.HasPrefix(line, "event:"):
+			event = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		case strings.HasPrefix(line, "data:"):
+			data += strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " ")
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return true, err
+	}
+	return true, fmt.Errorf("stream closed")
+}
+
+// send delivers a status without ever holding up the stream
+func send(status chan<- string, msg string) {
+	select {
+	case status <- msg:
+	default:
+	}
+}
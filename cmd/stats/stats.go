@@ -1,195 +1,251 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
-	"gogogo/modules/metrics"
 	"log"
+	"os"
 	"time"
 
+	"gogogo/middleware/metrics"
+
 	"github.com/gizak/termui/v3"
 	"github.com/gizak/termui/v3/widgets"
 )
 
+const historySize = 100
+
 type MetricsUI struct {
-	cpuChart      *widgets.Plot
-	memChart      *widgets.Plot
-	reqChart      *widgets.Plot
-	gauges        []*widgets.Gauge
-	requestList   *widgets.List
-	summaryText   *widgets.Paragraph
-	profilerStats *widgets.Paragraph
+	reqChart     *widgets.Plot
+	latencyChart *widgets.Plot
+	memChart     *widgets.Plot
+	gauges       []*widgets.Gauge
+	summaryText  *widgets.Paragraph
+	runtimeText  *widgets.Paragraph
+	routeTable   *widgets.Table
+	statusText   *widgets.Paragraph
+
+	top    int
+	status string
+	last   *metrics.Report
 }
 
-func NewMetricsUI() *MetricsUI {
+func NewMetricsUI(top int) *MetricsUI {
 	return &MetricsUI{
-		cpuChart:      widgets.NewPlot(),
-		memChart:      widgets.NewPlot(),
-		reqChart:      widgets.NewPlot(),
-		gauges:        make([]*widgets.Gauge, 3),
-		requestList:   widgets.NewList(),
-		summaryText:   widgets.NewParagraph(),
-		profilerStats: widgets.NewParagraph(),
+		reqChart:     widgets.NewPlot(),
+		latencyChart: widgets.NewPlot(),
+		memChart:     widgets.NewPlot(),
+		gauges:       make([]*widgets.Gauge, 2),
+		summaryText:  widgets.NewParagraph(),
+		runtimeText:  widgets.NewParagraph(),
+		routeTable:   widgets.NewTable(),
+		statusText:   widgets.NewParagraph(),
+		top:          top,
+		status:       "connecting",
 	}
 }
 
 func (m *MetricsUI) setupUI() {
 	termui.Clear()
 
-	m.cpuChart.Title = "CPU Usage"
-	m.cpuChart.LineColors = []termui.Color{termui.ColorGreen}
-	m.cpuChart.AxesColor = termui.ColorWhite
-	m.cpuChart.Data = make([][]float64, 1)
+	// Plots need two points to draw a line
+	m.reqChart.Title = "Requests/s"
+	m.reqChart.LineColors = []termui.Color{termui.ColorCyan}
+	m.reqChart.AxesColor = termui.ColorWhite
+	m.reqChart.Data = [][]float64{{0, 0}}
+
+	m.latencyChart.Title = "Latency p50 / p99 (ms)"
+	m.latencyChart.LineColors = []termui.Color{termui.ColorGreen, termui.ColorRed}
+	m.latencyChart.AxesColor = termui.ColorWhite
+	m.latencyChart.Data = [][]float64{{0, 0}, {0, 0}}
 
-	m.memChart.Title = "Memory Usage"
+	m.memChart.Title = "Heap (MB)"
 	m.memChart.LineColors = []termui.Color{termui.ColorYellow}
 	m.memChart.AxesColor = termui.ColorWhite
-	m.memChart.Data = make([][]float64, 1)
-
-	m.reqChart.Title = "Request Rate"
-	m.reqChart.LineColors = []termui.Color{termui.ColorCyan}
-	m.reqChart.AxesColor = termui.ColorWhite
-	m.reqChart.Data = make([][]float64, 1)
+	m.memChart.Data = [][]float64{{0, 0}}
 
 	for i := range m.gauges {
 		m.gauges[i] = widgets.NewGauge()
 		m.gauges[i].BarColor = termui.ColorBlue
 	}
 	m.gauges[0].Title = "Cache Hit Rate"
-	m.gauges[1].Title = "Memory Usage"
-	m.gauges[2].Title = "Active Goroutines"
-
-	m.requestList.Title = "Recent Requests"
-	m.requestList.TextStyle = termui.NewStyle(termui.ColorWhite)
-	m.requestList.WrapText = false
+	m.gauges[1].Title = "Error Rate (5xx)"
+	m.gauges[1].BarColor = termui.ColorRed
 
-	m.summaryText.Title = "Summary"
+	m.summaryText.Title = "Requests"
 	m.summaryText.TextStyle = termui.NewStyle(termui.ColorWhite)
 
-	m.profilerStats.Title = "Profiler Stats"
-	m.profilerStats.TextStyle = termui.NewStyle(termui.ColorWhite)
+	m.runtimeText.Title = "Runtime"
+	m.runtimeText.TextStyle = termui.NewStyle(termui.ColorWhite)
+
+	m.routeTable.Title = fmt.Sprintf("Slowest Routes (top %d by p99)", m.top)
+	m.routeTable.TextStyle = termui.NewStyle(termui.ColorWhite)
+	m.routeTable.RowSeparator = false
+	m.routeTable.RowStyles[0] = termui.NewStyle(termui.ColorWhite, termui.ColorClear, termui.ModifierBold)
+	m.routeTable.Rows = [][]string{routeHeader}
+
+	m.statusText.Border = false
+	m.statusText.TextStyle = termui.NewStyle(termui.ColorYellow)
 
 	m.layoutUI()
 }
 
+var routeHeader = []string{"Route", "Requests", "p50", "p90", "p99", "p999", "Max"}
+
 func (m *MetricsUI) layoutUI() {
 	termWidth, termHeight := termui.TerminalDimensions()
 
 	chartHeight := termHeight / 3
 	gaugeHeight := 3
-	summaryHeight := 5
+	textHeight := 7
 
 	// Charts
-	m.cpuChart.SetRect(0, 0, termWidth/3, chartHeight)
-	m.memChart.SetRect(termWidth/3, 0, 2*termWidth/3, chartHeight)
-	m.reqChart.SetRect(2*termWidth/3, 0, termWidth, chartHeight)
+	m.reqChart.SetRect(0, 0, termWidth/3, chartHeight)
+	m.latencyChart.SetRect(termWidth/3, 0, 2*termWidth/3, chartHeight)
+	m.memChart.SetRect(2*termWidth/3, 0, termWidth, chartHeight)
 
 	// Gauges
-	gaugeWidth := termWidth / 3
+	gaugeWidth := termWidth / len(m.gauges)
 	for i, gauge := range m.gauges {
 		gauge.SetRect(i*gaugeWidth, chartHeight, (i+1)*gaugeWidth, chartHeight+gaugeHeight)
 	}
 
-	// Summary
-	m.summaryText.SetRect(0, chartHeight+gaugeHeight, termWidth, chartHeight+gaugeHeight+summaryHeight)
-
-	// Request List
-	m.requestList.SetRect(0, chartHeight+gaugeHeight+summaryHeight, termWidth, termHeight)
+	// Summaries
+	top := chartHeight + gaugeHeight
+	m.summaryText.SetRect(0, top, termWidth/2, top+textHeight)
+	m.runtimeText.SetRect(termWidth/2, top, termWidth, top+textHeight)
 
-	profilerStatsHeight := 5
-	m.profilerStats.SetRect(0, termHeight-profilerStatsHeight, termWidth, termHeight)
+	// Routes, above the status line
+	m.routeTable.SetRect(0, top+textHeight, termWidth, termHeight-1)
+	m.routeTable.ColumnWidths = routeColumnWidths(termWidth - 2)
+	m.statusText.SetRect(0, termHeight-1, termWidth, termHeight)
 }
 
-func (m *MetricsUI) updateCharts() {
-	matr := metrics.GetMetrics()
-	serverMetrics := matr.GetServerMetrics()
+// routeColumnWidths gives the route column what the numbers leave over
+func routeColumnWidths(width int) []int {
+	widths := []int{0, 10, 10, 10, 10, 10, 10}
+	rest := width
+	for _, w := range widths[1:] {
+		rest -= w
+	}
+	widths[0] = max(rest, 10)
+	return widths
+}
 
-	cpuUsage := float64(serverMetrics.ActiveGoroutines) / 100.0 // This is a placeholder. You might want to implement actual CPU usage tracking.
-	memUsage := float64(serverMetrics.MemoryUsage) / (1024 * 1024)
-	reqRate := float64(serverMetrics.TotalRequests) // This should be requests per second. You might want to implement a rolling average.
+func (m *MetricsUI) update(report metrics.Report) {
+	prev := m.last
+	m.last = &report
+	server := report.Server
+	latency := report.Latency
+
+	push(&m.reqChart.Data[0], server.RequestRate)
+	push(&m.latencyChart.Data[0], ms(latency.All.P50))
+	push(&m.latencyChart.Data[1], ms(latency.All.P99))
+	push(&m.memChart.Data[0], float64(server.MemoryUsage)/(1024*1024))
+	for _, plot := range []*widgets.Plot{m.reqChart, m.latencyChart, m.memChart} {
+		plot.MaxVal = plotMax(plot.Data)
+	}
 
-	if len(m.cpuChart.Data[0]) >= 100 {
-		m.cpuChart.Data[0] = m.cpuChart.Data[0][1:]
+	m.gauges[0].Percent = int(server.CacheHitRate * 100)
+	m.gauges[1].Percent = 0
+	if latency.All.Count > 0 {
+		m.gauges[1].Percent = int(latency.Classes["5xx"].Count * 100 / latency.All.Count)
 	}
-	m.cpuChart.Data[0] = append(m.cpuChart.Data[0], cpuUsage)
 
-	if len(m.memChart.Data[0]) >= 100 {
-		m.memChart.Data[0] = m.memChart.Data[0][1:]
+	m.summaryText.Text = fmt.Sprintf(
+		"Total: %d   Rate: %.1f/s   Window: %v\nLatency  p50 %v   p90 %v\n         p99 %v   p999 %v\nMean: %v   Max: %v",
+		server.TotalRequests, server.RequestRate, latency.Window.Round(time.Second),
+		round(latency.All.P50), round(latency.All.P90),
+		round(latency.All.P99), round(latency.All.P999),
+		round(latency.All.Mean), round(latency.All.Max),
+	)
+
+	// Pause times since the previous report show GC pressure as it happens
+	var recentGC uint32
+	var recentPause time.Duration
+	if prev != nil && server.NumGC >= prev.Server.NumGC {
+		recentGC = server.NumGC - prev.Server.NumGC
+		recentPause = server.GCPauseTotal - prev.Server.GCPauseTotal
 	}
-	m.memChart.Data[0] = append(m.memChart.Data[0], memUsage)
+	m.runtimeText.Text = fmt.Sprintf(
+		"Goroutines: %d\nHeap: %.1f MB in %d objects\nGC: %d cycles, last pause %v, total %v\nSince last update: %d cycles, %v paused\nCache: %d entries",
+		server.ActiveGoroutines,
+		float64(server.MemoryUsage)/(1024*1024), server.HeapObjects,
+		server.NumGC, server.LastGCPause, round(server.GCPauseTotal),
+		recentGC, recentPause,
+		server.CacheSize,
+	)
 
-	if len(m.reqChart.Data[0]) >= 100 {
-		m.reqChart.Data[0] = m.reqChart.Data[0][1:]
+	rows := [][]string{routeHeader}
+	for _, route := range latency.SlowestRoutes(m.top) {
+		l := latency.Routes[route]
+		rows = append(rows, []string{
+			route, fmt.Sprint(l.Count),
+			round(l.P50).String(), round(l.P90).String(), round(l.P99).String(), round(l.P999).String(), round(l.Max).String(),
+		})
 	}
-	m.reqChart.Data[0] = append(m.reqChart.Data[0], reqRate)
+	m.routeTable.Rows = rows
 }
 
-func (m *MetricsUI) updateGauges() {
-	matr := metrics.GetMetrics()
-	serverMetrics := matr.GetServerMetrics()
-
-	m.gauges[0].Percent = int(float64(serverMetrics.CacheHitRate) / 1e4)                         // Convert from 1e6 fixed-point to percentage
-	m.gauges[1].Percent = int((float64(serverMetrics.MemoryUsage) / (1024 * 1024 * 1024)) * 100) // Assuming 1GB max
-	m.gauges[2].Percent = int((float64(serverMetrics.ActiveGoroutines) / 1000) * 100)            // Assuming 1000 max
+func (m *MetricsUI) render() {
+	m.statusText.Text = m.status + "   (q to quit)"
+	termui.Render(m.reqChart, m.latencyChart, m.memChart, m.gauges[0], m.gauges[1], m.summaryText, m.runtimeText, m.routeTable, m.statusText)
 }
 
-func (m *MetricsUI) updateRequestList() {
-	matr := metrics.GetMetrics()
-	requestMetrics := matr.GetRequestMetrics()
+// push appends a point to a plot line, keeping the last historySize
+func push(line *[]float64, v float64) {
+	*line = append(*line, v)
+	if len(*line) > historySize {
+		*line = (*line)[len(*line)-historySize:]
+	}
+}
 
-	items := make([]string, 0, len(requestMetrics))
-	for _, req := range requestMetrics {
-		if req.URL != "" { // Only add non-empty entries
-			items = append(items, fmt.Sprintf("%s - %dms - %d", req.URL, req.ResponseTime.Milliseconds(), req.StatusCode))
+// plotMax scales a plot to its data, never to zero which it cannot draw
+func plotMax(data [][]float64) float64 {
+	var top float64
+	for _, line := range data {
+		for _, v := range line {
+			top = max(top, v)
 		}
 	}
-	m.requestList.Rows = items
+	if top == 0 {
+		return 1
+	}
+	return top * 1.1
 }
 
-func (m *MetricsUI) updateSummary() {
-	matr := metrics.GetMetrics()
-	serverMetrics := matr.GetServerMetrics()
-
-	m.summaryText.Text = fmt.Sprintf(
-		"Total Requests: %d\nAverage Response Time: %.2fms\nCache Size: %d\nMemory Usage: %.2f MB",
-		serverMetrics.TotalRequests,
-		float64(serverMetrics.AverageResponse)/float64(time.Millisecond),
-		serverMetrics.CacheSize,
-		float64(serverMetrics.MemoryUsage)/(1024*1024),
-	)
+func ms(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
 }
 
-func (m *MetricsUI) updateProfilerStats(stats profiler.ProfileStats) {
-	m.profilerStats.Text = fmt.Sprintf(
-		"Uptime: %v\nAllocated Memory: %v MB\nTotal Allocations: %v MB\nSystem Memory: %v MB\nGC Runs: %d",
-		stats.Uptime.Round(time.Second),
-		stats.AllocatedMem/1024/1024,
-		stats.TotalAlloc/1024/1024,
-		stats.Sys/1024/1024,
-		stats.NumGC,
-	)
+func round(d time.Duration) time.Duration {
+	switch {
+	case d >= time.Second:
+		return d.Round(time.Millisecond)
+	case d >= time.Millisecond:
+		return d.Round(10 * time.Microsecond)
+	}
+	return d.Round(time.Microsecond)
 }
 
-func (m *MetricsUI) Run(profiler *profiler.Profiler) error {
+func (m *MetricsUI) Run(client *Client) error {
 	if err := termui.Init(); err != nil {
 		return fmt.Errorf("failed to initialize termui: %v", err)
 	}
 	defer termui.Close()
 
 	m.setupUI()
+	m.render()
 
-	updateUI := func() {
-		m.updateCharts()
-		m.updateGauges()
-		m.updateRequestList()
-		m.updateSummary()
-		m.updateProfilerStats(profiler.GetStats())
-	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 
-	updateUI()
+	reports := make(chan metrics.Report)
+	status := make(chan string, 16)
+	go client.Follow(ctx, reports, status)
 
 	uiEvents := termui.PollEvents()
-	ticker := time.NewTicker(time.Second).C
-
 	for {
 		select {
 		case e := <-uiEvents:
@@ -199,26 +255,29 @@ func (m *MetricsUI) Run(profiler *profiler.Profiler) error {
 			case "<Resize>":
 				m.layoutUI()
 				termui.Clear()
-				updateUI()
-				termui.Render(m.cpuChart, m.memChart, m.reqChart, m.gauges[0], m.gauges[1], m.gauges[2], m.summaryText, m.requestList, m.profilerStats)
+				m.render()
 			}
-		case <-ticker:
-			updateUI()
-			termui.Render(m.cpuChart, m.memChart, m.reqChart, m.gauges[0], m.gauges[1], m.gauges[2], m.summaryText, m.requestList, m.profilerStats)
+		case report := <-reports:
+			m.update(report)
+			m.render()
+		case s := <-status:
+			m.status = s
+			m.render()
 		}
 	}
 }
 
+// go run ./cmd/stats [-addr http://localhost:9090 | -socket gogogo.sock]
 func main() {
-	profiler := profiler.NewProfiler()
-	err := profiler.Start("../meta/cpu.prof", "../meta/mem.prof")
-	if err != nil {
-		log.Fatalf("Failed to start profiler: %v", err)
-	}
-	defer profiler.Stop()
-
-	ui := NewMetricsUI()
-	if err := ui.Run(profiler); err != nil {
+	addr := flag.String("addr", "http://localhost:9090", "admin address of the server")
+	socket := flag.String("socket", "", "admin Unix socket of the server, instead of -addr")
+	user := flag.String("user", "admin", "admin username, the password is read from GOGOGO_ADMIN_PASSWORD")
+	top := flag.Int("top", 10, "slowest routes listed")
+	flag.Parse()
+
+	ui := NewMetricsUI(*top)
+	client := NewClient(*addr, *socket, *user, os.Getenv("GOGOGO_ADMIN_PASSWORD"))
+	if err := ui.Run(client); err != nil {
 		log.Fatalf("Error running ui: %v", err)
 	}
 }
@@ -0,0 +1,338 @@
+package middleware
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gogogo/modules/reqinfo"
+)
+
+// Access log formats
+const (
+	FormatCommon   = "common"   // Common Log Format
+	FormatCombined = "combined" // Combined Log Format followed by the serving details
+	FormatJSON     = "json"     // One JSON object per line with every field
+)
+
+const clfTimeFormat = "02/Jan/2006:15:04:05 -0700"
+
+type AccessLogOptions struct {
+	Format        string
+	Buffer        int           // Entries queued for the writer, more are dropped
+	FlushInterval time.Duration // How often buffered lines are written out
+	Sample        float64       // Fraction of successful requests logged, 0 or 1 log all
+	Exclude       []string      // Path prefixes never logged
+}
+
+// AccessLog writes a line per request. Entries are handed to a background
+// writer over a buffered channel, so requests never wait on the disk; when
+// the writer falls behind entries are dropped rather than slowing them down.
+type AccessLog struct {
+	opts    AccessLogOptions
+	out     *bufio.Writer
+	entries chan accessEntry
+	quit    chan struct{}
+	done    chan struct{}
+	closing sync.Once
+	dropped atomic.Int64
+}
+
+type accessEntry struct {
+	time      time.Time
+	remote    string
+	user      string
+	method    string
+	uri       string
+	proto     string
+	referer   string
+	userAgent string
+	requestID string
+	status    int
+	bytes     int64
+	duration  time.Duration
+	cache     string
+	coalesced bool
+	distPath  string
+}
+
+// NewAccessLog starts an access log writing to w
+func NewAccessLog(w io.Writer, opts AccessLogOptions) (*AccessLog, error) {
+	switch opts.Format {
+	case "":
+		opts.Format = FormatCombined
+	case FormatCommon, FormatCombined, FormatJSON:
+	default:
+		return nil, fmt.Errorf("unknown access log format %q", opts.Format)
+	}
+	if opts.Buffer <= 0 {
+		opts.Buffer = 4096
+	}
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = time.Second
+	}
+
+	l := &AccessLog{
+		opts:    opts,
+		out:     bufio.NewWriterSize(w, 64*1024),
+		entries: make(chan accessEntry, opts.Buffer),
+		quit:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go l.run()
+	return l, nil
+}
+
+// Middleware logs the requests passing through it, recording what serving
+// them involved via reqinfo
+func (l *AccessLog) Middleware() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if l.excluded(r.URL.Path) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			start := time.Now()
+			ctx, info := reqinfo.NewContext(r.Context())
+			rw := newResponseRecorder(w)
+
+			// A panicking handler is logged as the 500 recovery answers with
+			panicked := true
+			defer func() {
+				status := rw.Status()
+				if panicked && !rw.Written() {
+					status = http.StatusInternalServerError
+				}
+				l.record(r, rw, status, start, info)
+			}()
+
+			next.ServeHTTP(rw, r.WithContext(ctx))
+			panicked = false
+		})
+	}
+}
+
+func (l *AccessLog) excluded(path string) bool {
+	for _, prefix := range l.opts.Exclude {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (l *AccessLog) record(r *http.Request, rw *responseRecorder, status int, start time.Time, info *reqinfo.Info) {
+	// Errors are always logged, sampling only thins out the rest
+	if status < 400 && l.opts.Sample > 0 && l.opts.Sample < 1 && rand.Float64() >= l.opts.Sample {
+		return
+	}
+
+	e := accessEntry{
+		time:      start,
+		remote:    r.RemoteAddr,
+		method:    r.Method,
+		uri:       r.RequestURI,
+		proto:     r.Proto,
+		referer:   r.Referer(),
+		userAgent: r.UserAgent(),
+		requestID: rw.Header().Get(RequestIDHeader),
+		status:    status,
+		bytes:     rw.bytes,
+		duration:  time.Since(start),
+		cache:     info.Cache(),
+		coalesced: info.WasCoalesced(),
+		distPath:  info.DistPath(),
+	}
+	if host, _, err := net.SplitHostPort(e.remote); err == nil {
+		e.remote = host
+	}
+	if user, _, ok := r.BasicAuth(); ok {
+		e.user = user
+	}
+
+	select {
+	case l.entries <- e:
+	default:
+		l.dropped.Add(1)
+	}
+}
+
+// Dropped counts the entries lost to a full buffer
+func (l *AccessLog) Dropped() int64 {
+	return l.dropped.Load()
+}
+
+// Close writes out the queued entries. Requests logged afterwards are lost.
+func (l *AccessLog) Close() error {
+	l.closing.Do(func() { close(l.quit) })
+	<-l.done
+	return nil
+}
+
+func (l *AccessLog) run() {
+	defer close(l.done)
+
+	ticker := time.NewTicker(l.opts.FlushInterval)
+	defer ticker.Stop()
+
+	var line []byte
+	var reported int64
+	for {
+		select {
+		case e := <-l.entries:
+			line = l.format(line[:0], &e)
+			l.out.Write(line)
+
+		case <-ticker.C:
+			l.flush()
+			if dropped := l.dropped.Load(); dropped > reported {
+				slog.Warn("Access log entries dropped, the buffer is full", "dropped", dropped-reported)
+				reported = dropped
+			}
+
+		case <-l.quit:
+			for {
+				select {
+				case e := <-l.entries:
+					line = l.format(line[:0], &e)
+					l.out.Write(line)
+				default:
+					l.flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+func (l *AccessLog) flush() {
+	if err := l.out.Flush(); err != nil {
+		slog.Error("Access log write failed", "error", err)
+	}
+}
+
+func (l *AccessLog) format(b []byte, e *accessEntry) []byte {
+	if l.opts.Format == FormatJSON {
+		return appendJSON(b, e)
+	}
+
+	// host ident authuser [time] "request" status bytes
+	b = appendField(b, e.remote)
+	b = append(b, " - "...)
+	b = appendField(b, e.user)
+	b = append(b, " ["...)
+	b = e.time.AppendFormat(b, clfTimeFormat)
+	b = append(b, `] "`...)
+	b = appendEscaped(b, e.method)
+	b = append(b, ' ')
+	b = appendEscaped(b, e.uri)
+	b = append(b, ' ')
+	b = appendEscaped(b, e.proto)
+	b = append(b, `" `...)
+	b = strconv.AppendInt(b, int64(e.status), 10)
+	b = append(b, ' ')
+	if e.bytes > 0 {
+		b = strconv.AppendInt(b, e.bytes, 10)
+	} else {
+		b = append(b, '-')
+	}
+
+	if l.opts.Format == FormatCombined {
+		b = append(b, ` "`...)
+		b = appendField(b, e.referer)
+		b = append(b, `" "`...)
+		b = appendField(b, e.userAgent)
+		b = append(b, `" rt=`...)
+		b = strconv.AppendFloat(b, e.duration.Seconds(), 'f', 6, 64)
+		b = append(b, " cache="...)
+		b = append(b, e.cache...)
+		b = append(b, " coalesced="...)
+		b = strconv.AppendBool(b, e.coalesced)
+		b = append(b, " dist="...)
+		b = appendField(b, e.distPath)
+		b = append(b, " id="...)
+		b = appendField(b, e.requestID)
+	}
+
+	return append(b, '\n')
+}
+
+// appendField writes "-" for empty values, as the log formats expect
+func appendField(b []byte, s string) []byte {
+	if s == "" {
+		return append(b, '-')
+	}
+	return appendEscaped(b, s)
+}
+
+// appendEscaped escapes quotes, backslashes and control characters the way
+// Apache does, a request cannot forge log lines
+func appendEscaped(b []byte, s string) []byte {
+	const hex = "0123456789abcdef"
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '"' || c == '\\':
+			b = append(b, '\\', c)
+		case c < ' ' || c == 0x7f:
+			b = append(b, '\\', 'x', hex[c>>4], hex[c&0xf])
+		default:
+			b = append(b, c)
+		}
+	}
+	return b
+}
+
+type jsonEntry struct {
+	Time       string  `json:"time"`
+	Remote     string  `json:"remote"`
+	User       string  `json:"user,omitempty"`
+	Method     string  `json:"method"`
+	URI        string  `json:"uri"`
+	Proto      string  `json:"proto"`
+	Status     int     `json:"status"`
+	Bytes      int64   `json:"bytes"`
+	DurationMS float64 `json:"duration_ms"`
+	Referer    string  `json:"referer,omitempty"`
+	UserAgent  string  `json:"user_agent,omitempty"`
+	RequestID  string  `json:"request_id,omitempty"`
+	Cache      string  `json:"cache"`
+	Coalesced  bool    `json:"coalesced"`
+	DistPath   string  `json:"dist_path,omitempty"`
+}
+
+func appendJSON(b []byte, e *accessEntry) []byte {
+	line, err := json.Marshal(jsonEntry{
+		Time:       e.time.Format(time.RFC3339Nano),
+		Remote:     e.remote,
+		User:       e.user,
+		Method:     e.method,
+		URI:        e.uri,
+		Proto:      e.proto,
+		Status:     e.status,
+		Bytes:      e.bytes,
+		DurationMS: float64(e.duration.Microseconds()) / 1000,
+		Referer:    e.referer,
+		UserAgent:  e.userAgent,
+		RequestID:  e.requestID,
+		Cache:      e.cache,
+		Coalesced:  e.coalesced,
+		DistPath:   e.distPath,
+	})
+	if err != nil {
+		return b
+	}
+	b = append(b, line...)
+	return append(b, '\n')
+}
@@ -0,0 +1,40 @@
+package middleware
+
+import (
+	"net/http"
+	"time"
+)
+
+// DefaultSecurityHeaders are sent when the configuration lists none
+var DefaultSecurityHeaders = map[string]string{
+	"X-Content-Type-Options": "nosniff",
+	"X-Frame-Options":        "SAMEORIGIN",
+	"Referrer-Policy":        "strict-origin-when-cross-origin",
+}
+
+// SecurityHeaders adds the given headers to every response
+func SecurityHeaders(headers map[string]string) func(http.Handler) http.Handler {
+	if len(headers) == 0 {
+		headers = DefaultSecurityHeaders
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			for name, value := range headers {
+				w.Header().Set(name, value)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Timeout answers 503 when a handler takes longer than d. Responses are
+// buffered until the handler returns, so it does not suit streams.
+func Timeout(d time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if d <= 0 {
+			return next
+		}
+		return http.TimeoutHandler(next, d, "Request timed out")
+	}
+}
@@ -0,0 +1,29 @@
+package middleware
+
+import (
+	"net/http"
+	"time"
+
+	"gogogo/modules/logger"
+)
+
+// Logging logs every request with its status, size and duration, through the
+// request logger so the request ID is included
+func Logging() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			rw := newResponseRecorder(w)
+
+			next.ServeHTTP(rw, r)
+
+			logger.FromContext(r.Context()).Info("Request",
+				"method", r.Method,
+				"uri", r.URL.RequestURI(),
+				"status", rw.Status(),
+				"bytes", rw.bytes,
+				"duration", time.Since(start).Round(time.Microsecond),
+			)
+		})
+	}
+}
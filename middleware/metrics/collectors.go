@@ -0,0 +1,98 @@
+package metrics
+
+import (
+	"runtime"
+	"strconv"
+
+	"gogogo/modules/cache"
+	"gogogo/modules/coalescer"
+	"gogogo/modules/router"
+)
+
+// CacheCollector exposes the size and counters of every cache shard
+func CacheCollector(c *cache.Cache) func(*Exposition) {
+	return func(e *Exposition) {
+		stats := c.Stats()
+
+		e.Describe("gogogo_cache_hits_total", "counter", "Cache lookups that found a live entry, by shard.")
+		for i, s := range stats {
+			e.Sample("gogogo_cache_hits_total", float64(s.Hits), "shard", strconv.Itoa(i))
+		}
+		e.Describe("gogogo_cache_misses_total", "counter", "Cache lookups that found no live entry, by shard.")
+		for i, s := range stats {
+			e.Sample("gogogo_cache_misses_total", float64(s.Misses), "shard", strconv.Itoa(i))
+		}
+		e.Describe("gogogo_cache_evictions_total", "counter", "Entries evicted to make room, by shard.")
+		for i, s := range stats {
+			e.Sample("gogogo_cache_evictions_total", float64(s.Evictions), "shard", strconv.Itoa(i))
+		}
+		e.Describe("gogogo_cache_items", "gauge", "Entries held, by shard.")
+		for i, s := range stats {
+			e.Sample("gogogo_cache_items", float64(s.Items), "shard", strconv.Itoa(i))
+		}
+	}
+}
+
+// CoalescerCollector exposes how many file reads the coalescer shared
+func CoalescerCollector(c *coalescer.Coalescer) func(*Exposition) {
+	return func(e *Exposition) {
+		stats := c.Stats()
+
+		e.Describe("gogogo_coalescer_calls_total", "counter", "Loads executed by the request coalescer.")
+		e.Sample("gogogo_coalescer_calls_total", float64(stats.Executed))
+		e.Describe("gogogo_coalescer_joined_total", "counter", "Waiters that joined a load already in flight instead of running their own.")
+		e.Sample("gogogo_coalescer_joined_total", float64(stats.Joined))
+		e.Describe("gogogo_coalescer_in_flight", "gauge", "Loads in flight.")
+		e.Sample("gogogo_coalescer_in_flight", float64(stats.InFlight))
+	}
+}
+
+// RouterCollector exposes router reloads and the build being served
+func RouterCollector(r *router.Router) func(*Exposition) {
+	return func(e *Exposition) {
+		succeeded, failed := r.ReloadCounts()
+
+		e.Describe("gogogo_router_reloads_total", "counter", "Router reloads, by result.")
+		e.Sample("gogogo_router_reloads_total", float64(succeeded), "result", "success")
+		e.Sample("gogogo_router_reloads_total", float64(failed), "result", "failure")
+
+		e.Describe("gogogo_build_info", "gauge", "The build being served, always 1.")
+		e.Sample("gogogo_build_info", 1, "build", r.BuildID())
+
+		if builtAt := r.BuiltAt(); !builtAt.IsZero() {
+			e.Describe("gogogo_build_timestamp_seconds", "gauge", "When the build being served was made.")
+			e.Sample("gogogo_build_timestamp_seconds", float64(builtAt.Unix()))
+		}
+	}
+}
+
+// RuntimeCollector exposes Go runtime statistics, read on every scrape
+func RuntimeCollector() func(*Exposition) {
+	return func(e *Exposition) {
+		var m runtime.MemStats
+		runtime.ReadMemStats(&m)
+
+		e.Describe("go_info", "gauge", "Go version the server was built with.")
+		e.Sample("go_info", 1, "version", runtime.Version())
+		e.Describe("go_goroutines", "gauge", "Goroutines that currently exist.")
+		e.Sample("go_goroutines", float64(runtime.NumGoroutine()))
+		e.Describe("go_gomaxprocs", "gauge", "Value of GOMAXPROCS.")
+		e.Sample("go_gomaxprocs", float64(runtime.GOMAXPROCS(0)))
+
+		e.Describe("go_memstats_alloc_bytes", "gauge", "Bytes of allocated heap objects.")
+		e.Sample("go_memstats_alloc_bytes", float64(m.Alloc))
+		e.Describe("go_memstats_alloc_bytes_total", "counter", "Bytes allocated for heap objects, freed or not.")
+		e.Sample("go_memstats_alloc_bytes_total", float64(m.TotalAlloc))
+		e.Describe("go_memstats_sys_bytes", "gauge", "Bytes of memory obtained from the OS.")
+		e.Sample("go_memstats_sys_bytes", float64(m.Sys))
+		e.Describe("go_memstats_heap_inuse_bytes", "gauge", "Bytes in in-use heap spans.")
+		e.Sample("go_memstats_heap_inuse_bytes", float64(m.HeapInuse))
+		e.Describe("go_memstats_heap_objects", "gauge", "Allocated heap objects.")
+		e.Sample("go_memstats_heap_objects", float64(m.HeapObjects))
+
+		e.Describe("go_gc_cycles_total", "counter", "Completed GC cycles.")
+		e.Sample("go_gc_cycles_total", float64(m.NumGC))
+		e.Describe("go_gc_pause_seconds_total", "counter", "Time spent in GC stop-the-world pauses.")
+		e.Sample("go_gc_pause_seconds_total", float64(m.PauseTotalNs)/1e9)
+	}
+}
@@ -0,0 +1,35 @@
+package metrics
+
+import (
+	"embed"
+	"net/http"
+)
+
+// Pages are built into the binary, they read their data from StreamPath
+//
+//go:embed dashboard/*.html
+var dashboardFiles embed.FS
+
+const DashboardPath = "/dashboard/"
+
+// Dashboard pages by path below DashboardPath
+var dashboards = map[string]string{
+	"":             "dashboard/dashboard.html",
+	"retro-futura": "dashboard/retro-futura.html",
+}
+
+// SetupDashboard mounts the dashboards, which must only be reachable behind
+// admin authentication
+func SetupDashboard(mux *http.ServeMux) {
+	mux.HandleFunc(DashboardPath, HandleDashboard)
+}
+
+func HandleDashboard(w http.ResponseWriter, r *http.Request) {
+	name, ok := dashboards[r.URL.Path[len(DashboardPath):]]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	http.ServeFileFS(w, r, dashboardFiles, name)
+}
@@ -1,103 +1,417 @@
 package metrics
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"runtime"
+	"sort"
+	"strconv"
 	"sync"
 	"sync/atomic"
 	"time"
+
+	"gogogo/modules/cache"
+	"gogogo/modules/reqinfo"
+)
+
+const (
+	// maxRoutes bounds the routes tracked one by one, later ones are
+	// recorded under OtherRoute
+	maxRoutes = 1000
+
+	OtherRoute    = "(other)"
+	NotFoundRoute = "(not found)" // 404s are not told apart by path
 )
 
+// Metrics records every request into lock-free sketches per route and per
+// status class, kept over a window of the retention period. Server stats
+// such as memory are sampled on the collection interval, not per request.
 type Metrics struct {
-	mu                  sync.RWMutex
-	ServerMetrics       ServerMetrics
-	RequestMetrics      [100]RequestMetric
-	requestMetricsIndex int32
+	requests Counter
+	window   atomic.Pointer[window]
+	classes  [6]series // By status / 100, 0 for anything out of range
+	routes   sync.Map  // Path to *series
+	nRoutes  atomic.Int32
+
+	mu       sync.RWMutex
+	server   ServerMetrics
+	cache    *cache.Cache
+	started  bool
+	interval time.Duration
+	lastTime time.Time
+	lastReqs uint64
+
+	groupsMu sync.Mutex
+	groups   []*GroupMetrics
 }
 
+// GroupMetrics counts the requests of one route group for Prometheus
+type GroupMetrics struct {
+	name     string
+	duration *Histogram
+	statuses [600]atomic.Uint64
+	inFlight atomic.Int64
+}
+
+// ServerMetrics is the state of the server at the last collection
 type ServerMetrics struct {
-	TotalRequests    int64
-	AverageResponse  int64 // Store as nanoseconds for atomic operations
-	CacheSize        int32
-	CacheHitRate     uint64 // Store as fixed-point number
-	MemoryUsage      uint64
-	ActiveGoroutines int32
+	TotalRequests    uint64
+	RequestRate      float64       // Per second since the previous collection
+	AverageResponse  time.Duration // Over the window
+	CacheSize        int
+	CacheHitRate     float64 // 0 to 1
+	MemoryUsage      uint64  // Bytes of allocated heap objects
+	HeapObjects      uint64
+	ActiveGoroutines int
+	NumGC            uint32
+	LastGCPause      time.Duration
+	GCPauseTotal     time.Duration
+	CollectedAt      time.Time
+}
+
+// Latency sums up the durations of a set of requests
+type Latency struct {
+	Count uint64
+	Mean  time.Duration
+	P50   time.Duration
+	P90   time.Duration
+	P99   time.Duration
+	P999  time.Duration
+	Max   time.Duration
 }
 
-type RequestMetric struct {
-	URL          string
-	ResponseTime time.Duration
-	MemoryUsed   uint64
-	StatusCode   int
+// LatencyReport holds the latencies of the requests within the window
+type LatencyReport struct {
+	Window  time.Duration
+	All     Latency
+	Classes map[string]Latency // "2xx" and so on
+	Routes  map[string]Latency
+}
+
+// Report is what the metrics stream sends on every collection
+type Report struct {
+	Server  ServerMetrics
+	Latency LatencyReport
 }
 
 var globalMetrics *Metrics
 
 func init() {
 	globalMetrics = &Metrics{}
+	globalMetrics.window.Store(newWindow(time.Hour, time.Now()))
 }
 
 func GetMetrics() *Metrics {
 	return globalMetrics
 }
 
-func (m *Metrics) UpdateServerMetrics(metrics ServerMetrics) {
+// Start collects server stats every interval and slides the latency window
+// along retention
+func (m *Metrics) Start(interval, retention time.Duration) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	m.ServerMetrics = metrics
+	if m.started {
+		return
+	}
+	m.started = true
+
+	if interval <= 0 {
+		interval = time.Second
+	}
+	m.interval = interval
+	if retention > 0 {
+		m.window.Store(newWindow(retention, time.Now()))
+	}
+	m.lastTime = time.Now()
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for now := range ticker.C {
+			m.collect(now)
+		}
+	}()
+}
+
+// WatchCache includes the size and hit rate of c in the server stats
+func (m *Metrics) WatchCache(c *cache.Cache) {
+	m.mu.Lock()
+	m.cache = c
+	m.mu.Unlock()
 }
 
-func (m *Metrics) AddRequestMetric(metric RequestMetric) {
+func (m *Metrics) collect(now time.Time) {
+	w := m.window.Load()
+	for due := w.due(now); due > 0; due-- {
+		next := w.advance(now)
+		for i := range m.classes {
+			m.classes[i].clear(next)
+		}
+		m.routes.Range(func(route, s any) bool {
+			s.(*series).clear(next)
+
+			// Routes without requests in the window make room for others
+			if s.(*series).empty() {
+				m.routes.Delete(route)
+				m.nRoutes.Add(-1)
+			}
+			return true
+		})
+		w.publish(next)
+	}
+
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	all := &Snapshot{}
+	for i := range m.classes {
+		all.Merge(m.classes[i].snapshot())
+	}
+
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	index := m.requestMetricsIndex % 100
-	m.RequestMetrics[index] = metric
-	m.requestMetricsIndex++
 
-	// Update server metrics
-	atomic.AddInt64(&m.ServerMetrics.TotalRequests, 1)
-	newAvg := (m.ServerMetrics.AverageResponse*int64(m.ServerMetrics.TotalRequests-1) + int64(metric.ResponseTime)) / int64(m.ServerMetrics.TotalRequests)
-	atomic.StoreInt64(&m.ServerMetrics.AverageResponse, newAvg)
+	total := m.requests.Load()
+	s := ServerMetrics{
+		TotalRequests:    total,
+		AverageResponse:  all.Mean(),
+		MemoryUsage:      mem.Alloc,
+		HeapObjects:      mem.HeapObjects,
+		ActiveGoroutines: runtime.NumGoroutine(),
+		NumGC:            mem.NumGC,
+		GCPauseTotal:     time.Duration(mem.PauseTotalNs),
+		CollectedAt:      now,
+	}
+	if mem.NumGC > 0 {
+		s.LastGCPause = time.Duration(mem.PauseNs[(mem.NumGC+255)%256])
+	}
+	if elapsed := now.Sub(m.lastTime).Seconds(); elapsed > 0 {
+		s.RequestRate = float64(total-m.lastReqs) / elapsed
+	}
+	if m.cache != nil {
+		var hits, misses uint64
+		for _, shard := range m.cache.Stats() {
+			s.CacheSize += shard.Items
+			hits += shard.Hits
+			misses += shard.Misses
+		}
+		if hits+misses > 0 {
+			s.CacheHitRate = float64(hits) / float64(hits+misses)
+		}
+	}
+
+	m.server = s
+	m.lastTime = now
+	m.lastReqs = total
+}
+
+// Observe records a served request
+func (m *Metrics) Observe(route string, status int, d time.Duration) {
+	m.requests.Add(1)
+	slot := int(m.window.Load().current.Load())
+
+	class := status / 100
+	if class < 1 || class > 5 {
+		class = 0
+	}
+	m.classes[class].observe(slot, d)
+
+	if status == http.StatusNotFound {
+		route = NotFoundRoute
+	}
+	m.route(route).observe(slot, d)
+}
+
+// route returns the series of a route, created on first use while there
+// is room
+func (m *Metrics) route(path string) *series {
+	if s, ok := m.routes.Load(path); ok {
+		return s.(*series)
+	}
+	if m.nRoutes.Load() >= maxRoutes {
+		path = OtherRoute
+		if s, ok := m.routes.Load(path); ok {
+			return s.(*series)
+		}
+	}
+
+	s, loaded := m.routes.LoadOrStore(path, &series{})
+	if !loaded {
+		m.nRoutes.Add(1)
+	}
+	return s.(*series)
+}
+
+// Group returns the metrics of the named route group, created on first use
+func (m *Metrics) Group(name string) *GroupMetrics {
+	m.groupsMu.Lock()
+	defer m.groupsMu.Unlock()
+
+	for _, g := range m.groups {
+		if g.name == name {
+			return g
+		}
+	}
+	g := &GroupMetrics{name: name, duration: NewHistogram(DurationBuckets)}
+	m.groups = append(m.groups, g)
+	return g
+}
+
+func (g *GroupMetrics) observe(status int, duration time.Duration) {
+	g.duration.Observe(duration.Seconds())
+	if status >= 0 && status < len(g.statuses) {
+		g.statuses[status].Add(1)
+	}
+}
+
+// WritePrometheus writes the request metrics of every route group, and the
+// latency quantiles of each status class over the window
+func (m *Metrics) WritePrometheus(e *Exposition) {
+	m.groupsMu.Lock()
+	groups := m.groups
+	m.groupsMu.Unlock()
+
+	e.Describe("gogogo_http_request_duration_seconds", "histogram", "Time taken to serve requests, by route group.")
+	for _, g := range groups {
+		g.duration.Write(e, "gogogo_http_request_duration_seconds", "group", g.name)
+	}
+
+	e.Describe("gogogo_http_requests_total", "counter", "Requests served, by route group and status code.")
+	for _, g := range groups {
+		for status := range g.statuses {
+			if n := g.statuses[status].Load(); n > 0 {
+				e.Sample("gogogo_http_requests_total", float64(n), "group", g.name, "code", strconv.Itoa(status))
+			}
+		}
+	}
+
+	e.Describe("gogogo_http_requests_in_flight", "gauge", "Requests being served, by route group.")
+	for _, g := range groups {
+		e.Sample("gogogo_http_requests_in_flight", float64(g.inFlight.Load()), "group", g.name)
+	}
+
+	e.Describe("gogogo_http_request_latency_seconds", "summary", "Latency quantiles over the retention window, by status class.")
+	for i := range m.classes {
+		sn := m.classes[i].snapshot()
+		if sn.Count == 0 {
+			continue
+		}
+		class := statusClass(i)
+		for _, q := range []float64{.5, .9, .99, .999} {
+			e.Sample("gogogo_http_request_latency_seconds", sn.Quantile(q).Seconds(), "class", class, "quantile", strconv.FormatFloat(q, 'g', -1, 64))
+		}
+		e.Sample("gogogo_http_request_latency_seconds_sum", sn.Sum.Seconds(), "class", class)
+		e.Sample("gogogo_http_request_latency_seconds_count", float64(sn.Count), "class", class)
+	}
+}
+
+func statusClass(i int) string {
+	if i == 0 {
+		return "other"
+	}
+	return strconv.Itoa(i) + "xx"
 }
 
 func (m *Metrics) GetServerMetrics() ServerMetrics {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
-	return m.ServerMetrics
+	return m.server
 }
 
-func (m *Metrics) GetRequestMetrics() [100]RequestMetric {
-	m.mu.RLock()
-	defer m.mu.RUnlock()
-	return m.RequestMetrics
+// Latencies reports the latency of each status class and route within the
+// window
+func (m *Metrics) Latencies() LatencyReport {
+	report := LatencyReport{
+		Window:  m.window.Load().span(time.Now()),
+		Classes: make(map[string]Latency),
+		Routes:  make(map[string]Latency),
+	}
+
+	all := &Snapshot{}
+	for i := range m.classes {
+		if sn := m.classes[i].snapshot(); sn.Count > 0 {
+			report.Classes[statusClass(i)] = latency(sn)
+			all.Merge(sn)
+		}
+	}
+	report.All = latency(all)
+	m.routes.Range(func(route, s any) bool {
+		if sn := s.(*series).snapshot(); sn.Count > 0 {
+			report.Routes[route.(string)] = latency(sn)
+		}
+		return true
+	})
+	return report
+}
+
+func latency(sn *Snapshot) Latency {
+	return Latency{
+		Count: sn.Count,
+		Mean:  sn.Mean(),
+		P50:   sn.Quantile(.5),
+		P90:   sn.Quantile(.9),
+		P99:   sn.Quantile(.99),
+		P999:  sn.Quantile(.999),
+		Max:   sn.Max,
+	}
 }
 
-func MetricsMiddleware() func(http.Handler) http.Handler {
+func (m *Metrics) Report() Report {
+	return Report{
+		Server:  m.GetServerMetrics(),
+		Latency: m.Latencies(),
+	}
+}
+
+// SlowestRoutes lists up to n routes of the report by descending p99
+func (r LatencyReport) SlowestRoutes(n int) []string {
+	routes := make([]string, 0, len(r.Routes))
+	for route := range r.Routes {
+		routes = append(routes, route)
+	}
+	sort.Slice(routes, func(i, j int) bool {
+		return r.Routes[routes[i]].P99 > r.Routes[routes[j]].P99
+	})
+	if len(routes) > n {
+		routes = routes[:n]
+	}
+	return routes
+}
+
+// MetricsMiddleware measures the requests of a route group
+func MetricsMiddleware(group string) func(http.Handler) http.Handler {
+	g := GetMetrics().Group(group)
+
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			start := time.Now()
+			g.inFlight.Add(1)
+			defer g.inFlight.Add(-1)
+
+			// Handlers record the route pattern that matched, the access
+			// log may have set up the record already
+			info := reqinfo.FromContext(r.Context())
+			if info == nil {
+				var ctx context.Context
+				ctx, info = reqinfo.NewContext(r.Context())
+				r = r.WithContext(ctx)
+			}
 
 			// Create a custom ResponseWriter to capture the status code
-			rw := &responseWriter{ResponseWriter: w}
+			rw := &responseWriter{ResponseWriter: w, statusCode: http.StatusOK}
 
 			// Call the next handler
 			next.ServeHTTP(rw, r)
 
 			duration := time.Since(start)
-
-			// Collect metrics
-			var m runtime.MemStats
-			runtime.ReadMemStats(&m)
-
-			metric := RequestMetric{
-				URL:          r.URL.Path,
-				ResponseTime: duration,
-				MemoryUsed:   m.Alloc,
-				StatusCode:   rw.statusCode,
+			g.observe(rw.statusCode, duration)
+			route := info.Route()
+			if route == "" {
+				route = r.URL.Path
 			}
-
-			GetMetrics().AddRequestMetric(metric)
+			GetMetrics().Observe(route, rw.statusCode, duration)
 		})
 	}
 }
@@ -112,33 +426,64 @@ func (rw *responseWriter) WriteHeader(code int) {
 	rw.ResponseWriter.WriteHeader(code)
 }
 
-func UpdateCacheMetrics(size int, hitRate float64) {
-	metrics := GetMetrics()
-	atomic.StoreInt32(&metrics.ServerMetrics.CacheSize, int32(size))
-	atomic.StoreUint64(&metrics.ServerMetrics.CacheHitRate, uint64(hitRate*1e6)) // Store as fixed-point
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
 }
 
 // metrics dashboard serve
-func SetupMetricsAPI() {
-	http.HandleFunc("/api/metrics/server", HandleServerMetrics)
-	http.HandleFunc("/api/metrics/requests", HandleRequestMetrics)
+func SetupMetricsAPI(mux *http.ServeMux) {
+	mux.HandleFunc("/api/metrics/server", HandleServerMetrics)
+	mux.HandleFunc("/api/metrics/requests", HandleRequestMetrics)
+	mux.HandleFunc(StreamPath, HandleStream)
 }
 
 func HandleServerMetrics(w http.ResponseWriter, r *http.Request) {
-	metrics := GetMetrics()
-	serverMetrics := metrics.GetServerMetrics()
-
-	// Convert AverageResponse from nanoseconds to a duration
-	serverMetrics.AverageResponse = time.Duration(serverMetrics.AverageResponse).Nanoseconds()
-
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(serverMetrics)
+	json.NewEncoder(w).Encode(GetMetrics().GetServerMetrics())
 }
 
 func HandleRequestMetrics(w http.ResponseWriter, r *http.Request) {
-	metrics := GetMetrics()
-	requestMetrics := metrics.GetRequestMetrics()
-
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(requestMetrics)
+	json.NewEncoder(w).Encode(GetMetrics().Latencies())
+}
+
+// StreamPath serves the metrics as Server-Sent Events
+const StreamPath = "/api/metrics/stream"
+
+// HandleStream sends a Report as a "metrics" event on every collection
+// interval until the client goes away
+func HandleStream(w http.ResponseWriter, r *http.Request) {
+	m := GetMetrics()
+	m.mu.RLock()
+	interval := m.interval
+	m.mu.RUnlock()
+	if interval <= 0 {
+		interval = time.Second
+	}
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		data, err := json.Marshal(m.Report())
+		if err != nil {
+			return
+		}
+		if _, err := fmt.Fprintf(w, "event: metrics\ndata: %s\n\n", data); err != nil {
+			return
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+		}
+	}
 }
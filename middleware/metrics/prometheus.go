@@ -0,0 +1,165 @@
+package metrics
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// DurationBuckets are the upper bounds, in seconds, of request duration
+// histograms. Most pages are served from memory, so they start well below
+// a millisecond.
+var DurationBuckets = []float64{.0001, .00025, .0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Registry serves metrics in the Prometheus text exposition format. Sources
+// write their current values on every scrape.
+type Registry struct {
+	mu      sync.Mutex
+	sources []func(*Exposition)
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// Register adds a source of metrics, written in registration order
+func (r *Registry) Register(source func(*Exposition)) {
+	r.mu.Lock()
+	r.sources = append(r.sources, source)
+	r.mu.Unlock()
+}
+
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	sources := r.sources
+	r.mu.Unlock()
+
+	e := &Exposition{}
+	for _, source := range sources {
+		source(e)
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(e.buf)
+}
+
+var helpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+
+// Exposition accumulates metrics in the text format. Every metric starts
+// with Describe, followed by its samples.
+type Exposition struct {
+	buf []byte
+}
+
+// Describe writes the HELP and TYPE lines of a metric, typ being "counter",
+// "gauge" or "histogram"
+func (e *Exposition) Describe(name, typ, help string) {
+	e.buf = append(e.buf, "# HELP "...)
+	e.buf = append(e.buf, name...)
+	e.buf = append(e.buf, ' ')
+	e.buf = append(e.buf, helpEscaper.Replace(help)...)
+	e.buf = append(e.buf, "\n# TYPE "...)
+	e.buf = append(e.buf, name...)
+	e.buf = append(e.buf, ' ')
+	e.buf = append(e.buf, typ...)
+	e.buf = append(e.buf, '\n')
+}
+
+// Sample writes one value, labels given as name, value pairs
+func (e *Exposition) Sample(name string, value float64, labels ...string) {
+	e.buf = append(e.buf, name...)
+	if len(labels) > 1 {
+		e.buf = append(e.buf, '{')
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				e.buf = append(e.buf, ',')
+			}
+			e.buf = append(e.buf, labels[i]...)
+			e.buf = append(e.buf, `="`...)
+			e.buf = appendLabelValue(e.buf, labels[i+1])
+			e.buf = append(e.buf, '"')
+		}
+		e.buf = append(e.buf, '}')
+	}
+	e.buf = append(e.buf, ' ')
+	e.buf = appendValue(e.buf, value)
+	e.buf = append(e.buf, '\n')
+}
+
+func appendLabelValue(b []byte, s string) []byte {
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			b = append(b, `\\`...)
+		case '"':
+			b = append(b, `\"`...)
+		case '\n':
+			b = append(b, `\n`...)
+		default:
+			b = append(b, s[i])
+		}
+	}
+	return b
+}
+
+func appendValue(b []byte, v float64) []byte {
+	switch {
+	case math.IsInf(v, 1):
+		return append(b, "+Inf"...)
+	case math.IsInf(v, -1):
+		return append(b, "-Inf"...)
+	case math.IsNaN(v):
+		return append(b, "NaN"...)
+	}
+	return strconv.AppendFloat(b, v, 'g', -1, 64)
+}
+
+// Histogram counts observations into buckets without locking
+type Histogram struct {
+	bounds []float64
+	counts []atomic.Uint64 // Per bucket, the last one past all bounds
+	sum    atomic.Uint64   // float64 bits
+}
+
+func NewHistogram(bounds []float64) *Histogram {
+	return &Histogram{
+		bounds: bounds,
+		counts: make([]atomic.Uint64, len(bounds)+1),
+	}
+}
+
+func (h *Histogram) Observe(v float64) {
+	i := 0
+	for i < len(h.bounds) && v > h.bounds[i] {
+		i++
+	}
+	h.counts[i].Add(1)
+
+	for {
+		old := h.sum.Load()
+		if h.sum.CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)+v)) {
+			return
+		}
+	}
+}
+
+// Write writes the cumulative buckets, sum and count of the histogram
+func (h *Histogram) Write(e *Exposition, name string, labels ...string) {
+	bucketLabels := append(labels[:len(labels):len(labels)], "le", "")
+	var cumulative uint64
+	for i := range h.counts {
+		cumulative += h.counts[i].Load()
+		le := "+Inf"
+		if i < len(h.bounds) {
+			le = strconv.FormatFloat(h.bounds[i], 'g', -1, 64)
+		}
+		bucketLabels[len(bucketLabels)-1] = le
+		e.Sample(name+"_bucket", float64(cumulative), bucketLabels...)
+	}
+	e.Sample(name+"_sum", math.Float64frombits(h.sum.Load()), labels...)
+	e.Sample(name+"_count", float64(cumulative), labels...)
+}
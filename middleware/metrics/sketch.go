@@ -0,0 +1,160 @@
+package metrics
+
+import (
+	"math"
+	"math/bits"
+	"math/rand/v2"
+	"sync/atomic"
+	"time"
+)
+
+// Sketch buckets are log-linear in the style of HDR histograms: every power
+// of two of nanoseconds is split into 16 linear sub-buckets, so a quantile
+// is off by at most half a bucket, about 3%. Durations up to 2^40ns, some
+// 18 minutes, are told apart, longer ones land in the last bucket.
+const (
+	subBucketBits = 4
+	subBuckets    = 1 << subBucketBits
+	maxValueBits  = 40
+	sketchBuckets = (maxValueBits-subBucketBits)*subBuckets + subBuckets
+)
+
+// Sketch is a latency histogram safe for concurrent use without locks.
+// Sketches share one bucket layout, so they merge by adding counts.
+type Sketch struct {
+	counts [sketchBuckets]atomic.Uint64
+	sum    atomic.Int64 // Nanoseconds
+	max    atomic.Int64
+}
+
+func (s *Sketch) Observe(d time.Duration) {
+	if d < 0 {
+		d = 0
+	}
+	s.counts[bucketIndex(uint64(d))].Add(1)
+	s.sum.Add(int64(d))
+
+	for {
+		max := s.max.Load()
+		if int64(d) <= max || s.max.CompareAndSwap(max, int64(d)) {
+			return
+		}
+	}
+}
+
+// bucketIndex keeps the top bits of v: its exponent picks the power of two
+// and the next bits the linear sub-bucket within it
+func bucketIndex(v uint64) int {
+	shift := bits.Len64(v) - subBucketBits - 1
+	if shift < 0 {
+		shift = 0
+	}
+	if shift > maxValueBits-subBucketBits-1 {
+		return sketchBuckets - 1
+	}
+	return shift<<subBucketBits + int(v>>shift)
+}
+
+// bucketBounds returns the range of values counted in bucket i
+func bucketBounds(i int) (lower, upper uint64) {
+	if i < 2*subBuckets {
+		return uint64(i), uint64(i) + 1
+	}
+	shift := i>>subBucketBits - 1
+	lower = uint64(i-shift<<subBucketBits) << shift
+	return lower, lower + 1<<shift
+}
+
+// Snapshot is a point-in-time copy of one or more merged sketches
+type Snapshot struct {
+	counts [sketchBuckets]uint64
+	Count  uint64
+	Sum    time.Duration
+	Max    time.Duration
+}
+
+// Add merges the current counts of a sketch into the snapshot
+func (sn *Snapshot) Add(s *Sketch) {
+	if s == nil {
+		return
+	}
+	for i := range s.counts {
+		if n := s.counts[i].Load(); n > 0 {
+			sn.counts[i] += n
+			sn.Count += n
+		}
+	}
+	sn.Sum += time.Duration(s.sum.Load())
+	if max := time.Duration(s.max.Load()); max > sn.Max {
+		sn.Max = max
+	}
+}
+
+// Merge adds the counts of another snapshot
+func (sn *Snapshot) Merge(o *Snapshot) {
+	for i, n := range o.counts {
+		sn.counts[i] += n
+	}
+	sn.Count += o.Count
+	sn.Sum += o.Sum
+	if o.Max > sn.Max {
+		sn.Max = o.Max
+	}
+}
+
+// Quantile estimates the duration below which the fraction q of the
+// observations fall, as the middle of the bucket holding that rank
+func (sn *Snapshot) Quantile(q float64) time.Duration {
+	if sn.Count == 0 {
+		return 0
+	}
+	rank := uint64(math.Ceil(q * float64(sn.Count)))
+	if rank < 1 {
+		rank = 1
+	}
+
+	var seen uint64
+	for i, n := range sn.counts {
+		seen += n
+		if seen >= rank {
+			lower, upper := bucketBounds(i)
+			mid := time.Duration(lower + (upper-lower)/2)
+			if mid > sn.Max {
+				return sn.Max
+			}
+			return mid
+		}
+	}
+	return sn.Max
+}
+
+func (sn *Snapshot) Mean() time.Duration {
+	if sn.Count == 0 {
+		return 0
+	}
+	return sn.Sum / time.Duration(sn.Count)
+}
+
+// counterShards spreads a Counter over cache lines so concurrent requests
+// rarely touch the same one
+const counterShards = 16
+
+// Counter is a monotonic counter sharded to avoid contention on hot paths
+type Counter struct {
+	shards [counterShards]struct {
+		n atomic.Uint64
+		_ [56]byte // Pad to a cache line
+	}
+}
+
+func (c *Counter) Add(n uint64) {
+	c.shards[rand.Uint32()%counterShards].n.Add(n)
+}
+
+func (c *Counter) Load() uint64 {
+	var total uint64
+	for i := range c.shards {
+		total += c.shards[i].n.Load()
+	}
+	return total
+}
@@ -0,0 +1,186 @@
+package metrics
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestBucketIndex(t *testing.T) {
+	tests := []struct {
+		v      uint64
+		bucket int
+	}{
+		{0, 0},
+		{1, 1},
+		{31, 31},
+		{32, 32},
+		{33, 32},
+		{34, 33},
+		{1000, 5<<subBucketBits + 31},
+		{1<<40 - 1, sketchBuckets - 1},
+
+		// Beyond the range everything lands in the last bucket
+		{1 << 40, sketchBuckets - 1},
+		{math.MaxUint64, sketchBuckets - 1},
+	}
+
+	for _, tt := range tests {
+		if got := bucketIndex(tt.v); got != tt.bucket {
+			t.Errorf("bucketIndex(%d) = %d, want %d", tt.v, got, tt.bucket)
+		}
+	}
+}
+
+func TestBucketBounds(t *testing.T) {
+	var prevUpper uint64
+	for i := 0; i < sketchBuckets; i++ {
+		lower, upper := bucketBounds(i)
+		if lower != prevUpper {
+			t.Fatalf("bucket %d starts at %d, want %d where bucket %d ends", i, lower, prevUpper, i-1)
+		}
+		if bucketIndex(lower) != i || bucketIndex(upper-1) != i {
+			t.Fatalf("bucket %d [%d, %d) maps to %d and %d", i, lower, upper, bucketIndex(lower), bucketIndex(upper-1))
+		}
+
+		// Buckets are at most 1/16 of their values wide
+		if width := upper - lower; lower >= 2*subBuckets && width*subBuckets > lower {
+			t.Errorf("bucket %d [%d, %d) is wider than a sub-bucket", i, lower, upper)
+		}
+		prevUpper = upper
+	}
+	if prevUpper != 1<<maxValueBits {
+		t.Errorf("buckets end at %d, want %d", prevUpper, uint64(1)<<maxValueBits)
+	}
+}
+
+func TestQuantile(t *testing.T) {
+	var s Sketch
+	for i := 1; i <= 10000; i++ {
+		s.Observe(time.Duration(i) * time.Microsecond)
+	}
+	sn := &Snapshot{}
+	sn.Add(&s)
+
+	tests := []struct {
+		q    float64
+		want time.Duration
+	}{
+		{0, time.Microsecond},
+		{0.5, 5 * time.Millisecond},
+		{0.9, 9 * time.Millisecond},
+		{0.99, 9900 * time.Microsecond},
+		{1, 10 * time.Millisecond},
+	}
+
+	for _, tt := range tests {
+		got := sn.Quantile(tt.q)
+		if diff := math.Abs(float64(got-tt.want)) / float64(tt.want); diff > 0.035 {
+			t.Errorf("Quantile(%v) = %v, want %v within 3.5%%", tt.q, got, tt.want)
+		}
+	}
+
+	if sn.Count != 10000 || sn.Max != 10*time.Millisecond || sn.Mean() != 5000500*time.Nanosecond {
+		t.Errorf("Count, Max, Mean = %d, %v, %v", sn.Count, sn.Max, sn.Mean())
+	}
+}
+
+func TestQuantileEdges(t *testing.T) {
+	if q := (&Snapshot{}).Quantile(0.5); q != 0 {
+		t.Errorf("empty Quantile = %v, want 0", q)
+	}
+
+	// The middle of the bucket lies above the only value, Max caps it
+	var s Sketch
+	s.Observe(1000)
+	s.Observe(-5)
+	sn := &Snapshot{}
+	sn.Add(&s)
+	if q := sn.Quantile(1); q != 1000 {
+		t.Errorf("Quantile(1) = %v, want the max of 1µs", q)
+	}
+	if q := sn.Quantile(0.5); q != 0 {
+		t.Errorf("Quantile(0.5) = %v, want negative durations counted as 0", q)
+	}
+
+	var merged Snapshot
+	merged.Merge(sn)
+	merged.Merge(sn)
+	if merged.Count != 4 || merged.Max != 1000 || merged.Quantile(1) != 1000 {
+		t.Errorf("merged Count, Max = %d, %v", merged.Count, merged.Max)
+	}
+}
+
+func TestWindowRotation(t *testing.T) {
+	t0 := time.Unix(1700000000, 0)
+	w := newWindow(12*time.Minute, t0)
+	if w.slot != time.Minute {
+		t.Fatalf("slot = %v, want 1m", w.slot)
+	}
+
+	var s series
+	s.observe(0, time.Millisecond)
+
+	tests := []struct {
+		elapsed time.Duration
+		due     int
+	}{
+		{0, 0},
+		{59 * time.Second, 0},
+		{time.Minute, 1},
+		{150 * time.Second, 2},
+		{time.Hour, windowSlots},
+	}
+	for _, tt := range tests {
+		if due := w.due(t0.Add(tt.elapsed)); due != tt.due {
+			t.Errorf("due after %v = %d, want %d", tt.elapsed, due, tt.due)
+		}
+	}
+
+	// Going around the window reuses the first slice, whose data is cleared
+	now := t0
+	for i := 1; i <= windowSlots; i++ {
+		now = now.Add(time.Minute)
+		next := w.advance(now)
+		if next != i%windowSlots {
+			t.Fatalf("advance %d = %d, want %d", i, next, i%windowSlots)
+		}
+		s.clear(next)
+		w.publish(next)
+		if i < windowSlots {
+			s.observe(next, time.Second)
+		}
+	}
+
+	if w.due(now) != 0 {
+		t.Errorf("due right after advancing = %d, want 0", w.due(now))
+	}
+	// The oldest slice in use started a minute in
+	if span := w.span(now.Add(30 * time.Second)); span != 11*time.Minute+30*time.Second {
+		t.Errorf("span = %v, want 11m30s", span)
+	}
+	if span := w.span(now.Add(90 * time.Second)); span != 12*time.Minute {
+		t.Errorf("span = %v, want the retention period of 12m", span)
+	}
+	if sn := s.snapshot(); sn.Count != windowSlots-1 || sn.Max != time.Second {
+		t.Errorf("snapshot Count, Max = %d, %v, want %d, 1s", sn.Count, sn.Max, windowSlots-1)
+	}
+
+	for i := range s.slots {
+		s.clear(i)
+	}
+	if !s.empty() {
+		t.Error("series not empty after clearing every slice")
+	}
+}
+
+func TestWindowSpan(t *testing.T) {
+	t0 := time.Unix(1700000000, 0)
+	w := newWindow(0, t0)
+	if w.slot != time.Minute {
+		t.Errorf("slot without retention = %v, want 1m", w.slot)
+	}
+	if span := w.span(t0.Add(90 * time.Second)); span != 90*time.Second {
+		t.Errorf("span = %v, want 1m30s", span)
+	}
+}
@@ -0,0 +1,102 @@
+package metrics
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// windowSlots is how many slices the retention period is cut into. The
+// oldest slice is dropped as a new one starts, so the window slides in steps
+// of a twelfth of the retention period.
+const windowSlots = 12
+
+// window tracks which slice requests are recorded into
+type window struct {
+	current atomic.Int32
+	starts  [windowSlots]atomic.Int64 // Unix nanoseconds, 0 for unused slices
+	slot    time.Duration             // Length of a slice
+}
+
+func newWindow(retention time.Duration, now time.Time) *window {
+	w := &window{slot: retention / windowSlots}
+	if w.slot <= 0 {
+		w.slot = time.Minute
+	}
+	w.starts[0].Store(now.UnixNano())
+	return w
+}
+
+// due reports how many slices passed since the current one started, up to
+// the whole window when collections were further apart than a slice
+func (w *window) due(now time.Time) int {
+	start := w.starts[w.current.Load()].Load()
+	n := int((now.UnixNano() - start) / int64(w.slot))
+	if n > windowSlots {
+		return windowSlots
+	}
+	return n
+}
+
+// advance starts the next slice, returning its index for the caller to
+// clear the data it held a full window ago
+func (w *window) advance(now time.Time) int {
+	next := (int(w.current.Load()) + 1) % windowSlots
+	w.starts[next].Store(now.UnixNano())
+	return next
+}
+
+// publish makes next the slice requests are recorded into, once cleared
+func (w *window) publish(next int) {
+	w.current.Store(int32(next))
+}
+
+// span is how far back the slices in use reach, at most the retention
+// period
+func (w *window) span(now time.Time) time.Duration {
+	oldest := now.UnixNano()
+	for i := range w.starts {
+		if start := w.starts[i].Load(); start != 0 && start < oldest {
+			oldest = start
+		}
+	}
+	return min(time.Duration(now.UnixNano()-oldest), w.slot*windowSlots)
+}
+
+// series is a latency sketch per window slice, allocated on first use so
+// idle routes cost little
+type series struct {
+	slots [windowSlots]atomic.Pointer[Sketch]
+}
+
+func (s *series) observe(slot int, d time.Duration) {
+	sketch := s.slots[slot].Load()
+	if sketch == nil {
+		sketch = &Sketch{}
+		if !s.slots[slot].CompareAndSwap(nil, sketch) {
+			sketch = s.slots[slot].Load()
+		}
+	}
+	sketch.Observe(d)
+}
+
+func (s *series) clear(slot int) {
+	s.slots[slot].Store(nil)
+}
+
+func (s *series) empty() bool {
+	for i := range s.slots {
+		if s.slots[i].Load() != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// snapshot merges the slices of the whole window
+func (s *series) snapshot() *Snapshot {
+	sn := &Snapshot{}
+	for i := range s.slots {
+		sn.Add(s.slots[i].Load())
+	}
+	return sn
+}
@@ -0,0 +1,53 @@
+package middleware
+
+import (
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+)
+
+// Recovery turns a panicking handler into a 500 response instead of a
+// dropped connection, logging the stack. serveError writes the response,
+// plain text is sent when it is nil.
+func Recovery(serveError func(w http.ResponseWriter, r *http.Request, status int)) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			rw := newResponseRecorder(w)
+			defer func() {
+				err := recover()
+				if err == nil {
+					return
+				}
+				// Handlers abort on purpose with this one, net/http stays quiet
+				if err == http.ErrAbortHandler {
+					panic(err)
+				}
+
+				// Recovery runs outside the request ID middleware, which leaves
+				// the ID on the response
+				slog.Error("Panic serving request",
+					"method", r.Method,
+					"path", r.URL.Path,
+					"request_id", rw.Header().Get(RequestIDHeader),
+					"panic", err,
+					"stack", string(debug.Stack()),
+				)
+				if rw.Written() {
+					return
+				}
+				if serveError != nil {
+					serveError(rw, r, http.StatusInternalServerError)
+					return
+				}
+
+				// Drop what the handler prepared for the response it never sent
+				for _, name := range []string{"Cache-Control", "Content-Encoding", "Content-Length", "ETag"} {
+					rw.Header().Del(name)
+				}
+				http.Error(rw, "Internal Server Error", http.StatusInternalServerError)
+			}()
+
+			next.ServeHTTP(rw, r)
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package middleware
+
+import (
+	"net"
+	"net/http"
+
+	"gogogo/modules/logger"
+	"gogogo/modules/tracing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = tracing.Tracer("gogogo/middleware")
+
+// Tracing starts a server span for every request, continuing the trace of a
+// W3C traceparent header. Handlers name it after the route they matched.
+func Tracing() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+
+			scheme := "http"
+			if r.TLS != nil {
+				scheme = "https"
+			}
+			client := r.RemoteAddr
+			if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+				client = host
+			}
+
+			ctx, span := tracer.Start(ctx, r.Method,
+				trace.WithSpanKind(trace.SpanKindServer),
+				trace.WithAttributes(
+					semconv.HTTPRequestMethodKey.String(r.Method),
+					semconv.URLPath(r.URL.Path),
+					semconv.URLScheme(scheme),
+					semconv.ServerAddress(r.Host),
+					semconv.ClientAddress(client),
+					semconv.UserAgentOriginal(r.UserAgent()),
+				),
+			)
+			defer span.End()
+			ctx = tracing.WithServerSpan(ctx, span, r.Method)
+
+			// Log lines of the request lead to its trace
+			if sc := span.SpanContext(); sc.IsValid() {
+				ctx = logger.WithContext(ctx, logger.FromContext(ctx).With("trace_id", sc.TraceID().String()))
+			}
+
+			rec := newResponseRecorder(w)
+			next.ServeHTTP(rec, r.WithContext(ctx))
+
+			status := rec.Status()
+			span.SetAttributes(
+				semconv.HTTPResponseStatusCode(status),
+				semconv.HTTPResponseBodySize(int(rec.bytes)),
+			)
+			if status >= http.StatusInternalServerError {
+				span.SetStatus(codes.Error, http.StatusText(status))
+			}
+		})
+	}
+}
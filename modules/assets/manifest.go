@@ -0,0 +1,140 @@
+package assets
+
+import (
+	"encoding/json"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// Manifest maps logical asset paths, the URL path without its leading slash
+// such as "static/app.js", to the fingerprinted URL the build wrote them to
+type Manifest struct {
+	urls  map[string]string
+	mutex sync.RWMutex
+}
+
+var (
+	htmlRefPattern = regexp.MustCompile(`(?i)(\s(?:src|href)\s*=\s*)("[^"]*"|'[^']*'|[^\s"'>]+)`)
+	cssURLPattern  = regexp.MustCompile(`url\(\s*("[^"]*"|'[^']*'|[^)\s]+)\s*\)`)
+)
+
+func New() *Manifest {
+	return &Manifest{
+		urls: make(map[string]string),
+	}
+}
+
+// Load replaces the manifest contents with the file at path
+func (m *Manifest) Load(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	urls := make(map[string]string)
+	if err := json.Unmarshal(data, &urls); err != nil {
+		return err
+	}
+
+	m.mutex.Lock()
+	m.urls = urls
+	m.mutex.Unlock()
+	return nil
+}
+
+// Save writes the manifest as JSON to path
+func (m *Manifest) Save(path string) error {
+	m.mutex.RLock()
+	data, err := json.MarshalIndent(m.urls, "", "  ")
+	m.mutex.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	tempFile := path + ".tmp"
+	if err := os.WriteFile(tempFile, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tempFile, path)
+}
+
+func (m *Manifest) Set(logical string, url string) {
+	m.mutex.Lock()
+	m.urls[logical] = url
+	m.mutex.Unlock()
+}
+
+// Lookup returns the fingerprinted URL of a logical path
+func (m *Manifest) Lookup(logical string) (string, bool) {
+	m.mutex.RLock()
+	url, ok := m.urls[logical]
+	m.mutex.RUnlock()
+	return url, ok
+}
+
+// RewriteHTML points absolute src and href URLs at their fingerprinted files
+func (m *Manifest) RewriteHTML(src []byte) []byte {
+	return htmlRefPattern.ReplaceAllFunc(src, func(match []byte) []byte {
+		parts := htmlRefPattern.FindSubmatch(match)
+		value, quote := unquote(string(parts[2]))
+		if !strings.HasPrefix(value, "/") {
+			return match
+		}
+
+		url, ok := m.resolve("", value)
+		if !ok {
+			return match
+		}
+		return []byte(string(parts[1]) + quote + url + quote)
+	})
+}
+
+// RewriteCSS points url() references at their fingerprinted files, relative
+// references are resolved against base, the logical directory of the sheet
+func (m *Manifest) RewriteCSS(src []byte, base string) []byte {
+	return cssURLPattern.ReplaceAllFunc(src, func(match []byte) []byte {
+		parts := cssURLPattern.FindSubmatch(match)
+		value, quote := unquote(string(parts[1]))
+
+		url, ok := m.resolve(base, value)
+		if !ok {
+			return match
+		}
+		return []byte("url(" + quote + url + quote + ")")
+	})
+}
+
+// resolve maps a reference to its fingerprinted URL, keeping any query or
+// fragment. External, data and templated references are left alone.
+func (m *Manifest) resolve(base string, ref string) (string, bool) {
+	if ref == "" || strings.Contains(ref, "://") || strings.HasPrefix(ref, "//") ||
+		strings.HasPrefix(ref, "#") || strings.HasPrefix(ref, "data:") || strings.Contains(ref, "{{") {
+		return "", false
+	}
+
+	target, suffix := ref, ""
+	if i := strings.IndexAny(ref, "?#"); i >= 0 {
+		target, suffix = ref[:i], ref[i:]
+	}
+
+	logical := strings.TrimPrefix(target, "/")
+	if !strings.HasPrefix(target, "/") {
+		logical = path.Join(base, target)
+	}
+
+	url, ok := m.Lookup(logical)
+	if !ok {
+		return "", false
+	}
+	return url + suffix, true
+}
+
+func unquote(value string) (string, string) {
+	if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+		return value[1 : len(value)-1], value[:1]
+	}
+	return value, ""
+}
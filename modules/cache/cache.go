@@ -28,6 +28,18 @@ type Shard struct {
     items    *btree.BTree
     lock     sync.RWMutex
     maxItems int
+
+    hits      atomic.Uint64
+    misses    atomic.Uint64
+    evictions atomic.Uint64
+}
+
+// ShardStats describes one shard for monitoring
+type ShardStats struct {
+    Items     int
+    Hits      uint64
+    Misses    uint64
+    Evictions uint64
 }
 
 type Cache struct {
@@ -142,6 +154,7 @@ func (c *Cache) Get(key string) ([]byte, bool) {
     item := shard.items.Get(CacheEntry{Key: key})
     if item == nil {
         shard.lock.RUnlock()
+        shard.misses.Add(1)
         return nil, false
     }
 
@@ -150,12 +163,14 @@ func (c *Cache) Get(key string) ([]byte, bool) {
 
     if now > entry.Expiry {
         shard.lock.RUnlock()
+        shard.misses.Add(1)
         go c.cleanupEntry(shard, entry)
         return nil, false
     }
 
     value := entry.Value
     shard.lock.RUnlock()
+    shard.hits.Add(1)
 
     go c.updateEntryStats(shard, entry)
     return value, true
@@ -182,6 +197,16 @@ func (c *Cache) Set(key string, value []byte, expiry time.Time) {
     shard.lock.Unlock()
 }
 
+// Delete removes key from the cache if present
+func (c *Cache) Delete(key string) {
+    idx := c.shardIndex(key)
+    shard := c.shards[idx]
+
+    shard.lock.Lock()
+    shard.items.Delete(CacheEntry{Key: key})
+    shard.lock.Unlock()
+}
+
 func (c *Cache) cleanupEntry(shard *Shard, entry CacheEntry) {
     shard.lock.Lock()
     if current := shard.items.Get(CacheEntry{Key: entry.Key}); current != nil {
@@ -198,7 +223,7 @@ func (c *Cache) updateEntryStats(shard *Shard, entry CacheEntry) {
     if current := shard.items.Get(CacheEntry{Key: entry.Key}); current != nil {
         currentEntry := current.(CacheEntry)
         if currentEntry.Expiry == entry.Expiry {
-            currentEntry.Frequency = atomic.AddUint32(&currentEntry.Frequency, 1)
+            currentEntry.Frequency++
             currentEntry.LastAccess = time.Now().Unix()
             shard.items.Set(currentEntry)
         }
@@ -224,6 +249,7 @@ func (c *Cache) evict(shard *Shard) {
         for _, entry := range candidates {
             shard.items.Delete(entry)
         }
+        shard.evictions.Add(uint64(len(candidates)))
     }
 }
 
@@ -246,10 +272,18 @@ func (c *Cache) rebalanceShards(newCount int32) {
     // Reinitialize shards
     shardSize := c.maxSize / int(newCount)
     for i := int32(0); i < newCount; i++ {
+        old := c.shards[i]
         c.shards[i] = &Shard{
             items:    btree.New(entryCompare),
             maxItems: shardSize,
         }
+
+        // Keep the counters monotonic for monitoring
+        if old != nil {
+            c.shards[i].hits.Store(old.hits.Load())
+            c.shards[i].misses.Store(old.misses.Load())
+            c.shards[i].evictions.Store(old.evictions.Load())
+        }
     }
 
     // Redistribute entries
@@ -271,3 +305,19 @@ func (c *Cache) Clear() {
         shard.lock.Unlock()
     }
 }
+
+// Stats reports the size and counters of every active shard
+func (c *Cache) Stats() []ShardStats {
+    activeShards := atomic.LoadInt32(&c.activeShard)
+    stats := make([]ShardStats, activeShards)
+    for i := int32(0); i < activeShards; i++ {
+        shard := c.shards[i]
+        shard.lock.RLock()
+        stats[i].Items = shard.items.Len()
+        shard.lock.RUnlock()
+        stats[i].Hits = shard.hits.Load()
+        stats[i].Misses = shard.misses.Load()
+        stats[i].Evictions = shard.evictions.Load()
+    }
+    return stats
+}
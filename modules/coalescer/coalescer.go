@@ -1,12 +1,21 @@
 package coalescer
 
 import (
+	"context"
 	"hash/fnv"
 	"sync"
+	"sync/atomic"
+
+	"gogogo/modules/tracing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const shardCount = 32 // Balance between memory usage and lock contention
 
+var tracer = tracing.Tracer("gogogo/modules/coalescer")
+
 type Call struct {
 	wg     sync.WaitGroup
 	val    []byte
@@ -17,6 +26,16 @@ type Call struct {
 type Shard struct {
 	sync.RWMutex
 	calls map[string]*Call
+
+	executed atomic.Uint64 // Calls that ran fn
+	joined   atomic.Uint64 // Calls that waited on another one instead
+}
+
+// Stats sums up the work the coalescer saved
+type Stats struct {
+	Executed uint64
+	Joined   uint64
+	InFlight int // Keys being loaded right now
 }
 
 type Coalescer struct {
@@ -38,16 +57,25 @@ func (c *Coalescer) getShard(key string) *Shard {
 	return &c.shards[h.Sum32()%shardCount]
 }
 
-// Do coalesces multiple requests for the same key into a single operation
-func (c *Coalescer) Do(key string, fn func() ([]byte, error)) ([]byte, error) {
+// Do coalesces multiple requests for the same key into a single operation,
+// shared reports whether the result came from another caller's call. Its
+// span covers the wait of callers that joined one.
+func (c *Coalescer) Do(ctx context.Context, key string, fn func(context.Context) ([]byte, error)) (val []byte, err error, shared bool) {
+    ctx, span := tracer.Start(ctx, "coalescer.Do", trace.WithAttributes(attribute.String("coalescer.key", key)))
+    defer func() {
+        span.SetAttributes(attribute.Bool("coalescer.shared", shared))
+        tracing.End(span, err)
+    }()
+
     shard := c.getShard(key)
 
     // Fast path with read lock
     shard.RLock()
     if call, ok := shard.calls[key]; ok {
         shard.RUnlock()
+        shard.joined.Add(1)
         call.wg.Wait()
-        return call.val, call.err
+        return call.val, call.err, true
     }
     shard.RUnlock()
 
@@ -55,17 +83,19 @@ func (c *Coalescer) Do(key string, fn func() ([]byte, error)) ([]byte, error) {
     shard.Lock()
     if call, ok := shard.calls[key]; ok {
         shard.Unlock()
+        shard.joined.Add(1)
         call.wg.Wait()
-        return call.val, call.err
+        return call.val, call.err, true
     }
 
     call := &Call{}
     call.wg.Add(1)
     shard.calls[key] = call
     shard.Unlock()
+    shard.executed.Add(1)
 
     // Execute function
-    call.val, call.err = fn()
+    call.val, call.err = fn(ctx)
 
     // Cleanup with write lock
     shard.Lock()
@@ -73,5 +103,18 @@ func (c *Coalescer) Do(key string, fn func() ([]byte, error)) ([]byte, error) {
     shard.Unlock()
 
     call.wg.Done()
-    return call.val, call.err
+    return call.val, call.err, false
+}
+
+func (c *Coalescer) Stats() Stats {
+	var stats Stats
+	for i := range c.shards {
+		shard := &c.shards[i]
+		stats.Executed += shard.executed.Load()
+		stats.Joined += shard.joined.Load()
+		shard.RLock()
+		stats.InFlight += len(shard.calls)
+		shard.RUnlock()
+	}
+	return stats
 }
@@ -0,0 +1,198 @@
+package compression
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Content codings, named as in Accept-Encoding and Content-Encoding
+const (
+	Brotli = "br"
+	Zstd   = "zstd"
+	Gzip   = "gzip"
+)
+
+// Encodings lists the supported codings by preference, used to break ties
+// between codings a client accepts equally
+var Encodings = []string{Brotli, Zstd, Gzip}
+
+var (
+	zstdEncoder     *zstd.Encoder
+	zstdEncoderOnce sync.Once
+)
+
+// Extension is the file suffix of precompressed variants
+func Extension(encoding string) string {
+	switch encoding {
+	case Brotli:
+		return ".br"
+	case Zstd:
+		return ".zst"
+	case Gzip:
+		return ".gz"
+	}
+	return ""
+}
+
+// VariantETag derives the ETag of an encoded variant from the ETag of the
+// original, the two representations must not validate each other
+func VariantETag(etag string, encoding string) string {
+	if etag == "" {
+		return ""
+	}
+	return strings.TrimSuffix(etag, `"`) + "-" + encoding + `"`
+}
+
+// Compress encodes data at the best ratio, for build-time use where time
+// matters less than bytes on the wire
+func Compress(encoding string, data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+
+	switch encoding {
+	case Brotli:
+		bw := brotli.NewWriterLevel(&buf, brotli.BestCompression)
+		if _, err := bw.Write(data); err != nil {
+			return nil, err
+		}
+		if err := bw.Close(); err != nil {
+			return nil, err
+		}
+	case Zstd:
+		zstdEncoderOnce.Do(func() {
+			zstdEncoder, _ = zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedBestCompression))
+		})
+		return zstdEncoder.EncodeAll(data, make([]byte, 0, len(data)/2)), nil
+	case Gzip:
+		gw, err := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := gw.Write(data); err != nil {
+			return nil, err
+		}
+		if err := gw.Close(); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported encoding %q", encoding)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// Compressible reports whether content of the given type shrinks when
+// compressed, already compressed images, fonts and archives do not
+func Compressible(contentType string) bool {
+	contentType, _, _ = strings.Cut(contentType, ";")
+	contentType = strings.TrimSpace(strings.ToLower(contentType))
+
+	if strings.HasPrefix(contentType, "text/") {
+		return true
+	}
+	switch contentType {
+	case "application/javascript", "application/json", "application/xml",
+		"application/manifest+json", "application/wasm", "image/svg+xml",
+		"image/x-icon", "font/ttf", "font/otf":
+		return true
+	}
+	return strings.HasSuffix(contentType, "+json") || strings.HasSuffix(contentType, "+xml")
+}
+
+// Negotiate picks the coding to answer an Accept-Encoding header with among
+// those available, the one with the highest quality and on a tie the most
+// preferred. It returns "" when the response should not be encoded.
+func Negotiate(acceptEncoding string, available func(encoding string) bool) string {
+	if acceptEncoding == "" {
+		return ""
+	}
+
+	best, bestQuality := "", 0.0
+	for _, encoding := range Encodings {
+		if !available(encoding) {
+			continue
+		}
+		if q := quality(acceptEncoding, encoding); q > bestQuality {
+			best, bestQuality = encoding, q
+		}
+	}
+	return best
+}
+
+// quality returns the q value the header gives encoding, falling back to the
+// wildcard and to 0 when neither is listed
+func quality(header string, encoding string) float64 {
+	wildcard := 0.0
+	for header != "" {
+		var item string
+		item, header, _ = strings.Cut(header, ",")
+
+		name, params, _ := strings.Cut(item, ";")
+		name = strings.TrimSpace(name)
+
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+
+		if strings.EqualFold(name, encoding) {
+			return q
+		}
+		if name == "*" {
+			wildcard = q
+		}
+	}
+	return wildcard
+}
+
+// Writer is a streaming encoder, taken from a pool with NewWriter and given
+// back with Release once closed
+type Writer interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+// Streaming levels favour speed, responses are compressed as they are written
+var writerPools = map[string]*sync.Pool{
+	Brotli: {New: func() any { return brotli.NewWriterLevel(nil, 4) }},
+	Zstd: {New: func() any {
+		enc, _ := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedDefault), zstd.WithEncoderConcurrency(1))
+		return enc
+	}},
+	Gzip: {New: func() any {
+		gw, _ := gzip.NewWriterLevel(nil, gzip.DefaultCompression)
+		return gw
+	}},
+}
+
+// NewWriter returns a pooled encoder for encoding writing to w, nil if the
+// encoding is not supported
+func NewWriter(encoding string, w io.Writer) Writer {
+	pool, ok := writerPools[encoding]
+	if !ok {
+		return nil
+	}
+	writer := pool.Get().(Writer)
+	writer.Reset(w)
+	return writer
+}
+
+// Release returns a closed encoder to its pool
+func Release(encoding string, writer Writer) {
+	if pool, ok := writerPools[encoding]; ok {
+		writer.Reset(nil)
+		pool.Put(writer)
+	}
+}
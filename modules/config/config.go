@@ -2,6 +2,7 @@ package config
 
 import (
 	"crypto/tls"
+	"os"
 	"time"
 
 	"github.com/BurntSushi/toml"
@@ -26,6 +27,22 @@ type Config struct {
 		TLSConfig        *tls.Config   `toml:"tls"`
 	} `toml:"server"`
 
+	// Middleware by name, global wraps every route, groups only theirs
+	Middleware struct {
+		Global          []string            `toml:"global"`
+		Groups          map[string][]string `toml:"groups"` // "web", "spa", "static" or "api"
+		Timeout         time.Duration       `toml:"timeout"`
+		SecurityHeaders map[string]string   `toml:"security_headers"`
+	} `toml:"middleware"`
+
+	// Compression of responses at request time, precompressed files are
+	// served as they are
+	Compression struct {
+		Enabled bool     `toml:"enabled"`
+		MinSize int      `toml:"min_size"`
+		Types   []string `toml:"types"`
+	} `toml:"compression"`
+
 	Cache struct {
 		MaxSize           int           `toml:"max_size"`
 		DefaultExpiration time.Duration `toml:"default_expiration"`
@@ -37,10 +54,51 @@ type Config struct {
 	} `toml:"metrics"`
 
 	Logging struct {
-		Level string `toml:"level"`
-		File  string `toml:"file"`
+		Level       string        `toml:"level"`
+		Format      string        `toml:"format"` // "text" or "json"
+		File        string        `toml:"file"`
+		Console     bool          `toml:"console"`  // Also log to stderr when logging to a file
+		MaxSize     int           `toml:"max_size"` // Megabytes
+		RotateEvery time.Duration `toml:"rotate_every"`
+		MaxBackups  int           `toml:"max_backups"`
+		MaxAge      time.Duration `toml:"max_age"`
 	} `toml:"logging"`
 
+	// Listener for operational endpoints such as /metrics, keep it off
+	// public interfaces
+	Admin struct {
+		Enabled bool   `toml:"enabled"`
+		Address string `toml:"address"` // Empty to serve on the socket only
+		Socket  string `toml:"socket"`  // Unix socket path, empty for none
+
+		// Basic authentication for the address, the socket relies on its
+		// file mode. The dashboard is only served with a password set.
+		Username string `toml:"username"`
+		Password string `toml:"password"` // GOGOGO_ADMIN_PASSWORD takes precedence
+	} `toml:"admin"`
+
+	// Access log written per request, apart from the server log
+	AccessLog struct {
+		Format        string        `toml:"format"` // "common", "combined" or "json"
+		File          string        `toml:"file"`   // Empty writes to stdout
+		Buffer        int           `toml:"buffer"` // Entries queued for the writer
+		FlushInterval time.Duration `toml:"flush_interval"`
+		Sample        float64       `toml:"sample"`  // Fraction of successful requests logged
+		Exclude       []string      `toml:"exclude"` // Path prefixes never logged
+	} `toml:"access_log"`
+
+	// OpenTelemetry spans of the request path
+	Tracing struct {
+		Enabled     bool              `toml:"enabled"`
+		Exporter    string            `toml:"exporter"` // "otlp" or "stdout"
+		Endpoint    string            `toml:"endpoint"` // Collector host:port or URL
+		Insecure    bool              `toml:"insecure"`
+		Headers     map[string]string `toml:"headers"`
+		File        string            `toml:"file"` // Written by the stdout exporter, empty for stdout
+		SampleRatio float64           `toml:"sample_ratio"`
+		ServiceName string            `toml:"service_name"`
+	} `toml:"tracing"`
+
 	Directories struct {
 		Web       string `toml:"web"`
 		Content   string `toml:"content"`
@@ -62,8 +120,23 @@ type Config struct {
 	} `toml:"templates"`
 
 	Build struct {
-		IgnoreFile string `toml:"ignore_file"`
+		IgnoreFile      string   `toml:"ignore_file"`
+		Precompress     []string `toml:"precompress"`       // Content codings to write next to dist files
+		CompressMinSize int      `toml:"compress_min_size"` // Smaller files are only served uncompressed
 	} `toml:"build"`
+
+	Router struct {
+		Format string `toml:"format"` // "tree" or "table"
+	} `toml:"router"`
+
+	// Cache-Control by URL prefix, the longest matching prefix applies
+	CacheControl []CachePolicy `toml:"cache_control"`
+}
+
+type CachePolicy struct {
+	Prefix string `toml:"prefix"`
+	Policy string `toml:"policy"`
+	Hashed string `toml:"hashed"` // For fingerprinted files and SPA payloads of the current build
 }
 
 func LoadConfig(path string) (Config, error) {
@@ -71,5 +144,10 @@ func LoadConfig(path string) (Config, error) {
 
 	toml.DecodeFile(path, &cfg)
 
+	// Secrets are better kept out of the config file
+	if password := os.Getenv("GOGOGO_ADMIN_PASSWORD"); password != "" {
+		cfg.Admin.Password = password
+	}
+
 	return cfg, nil
 }
@@ -42,3 +42,8 @@ func (fa *FileAccess) Open(path string) (*os.File, error) {
 func (fa *FileAccess) Stat(path string) (os.FileInfo, error) {
 	return os.Stat(path)
 }
+
+// ReadDir returns the directory entries of path
+func (fa *FileAccess) ReadDir(path string) ([]os.DirEntry, error) {
+	return os.ReadDir(path)
+}
@@ -1,30 +1,43 @@
 package filemanager
 
 import (
+	"context"
 	"errors"
+	"mime"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"gogogo/modules/cache"
 	"gogogo/modules/coalescer"
 	"gogogo/modules/fileaccess"
+	"gogogo/modules/reqinfo"
 	"gogogo/modules/router"
+	"gogogo/modules/tracing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 var (
 	ErrNotFound = errors.New("file not found")
 )
 
+var tracer = tracing.Tracer("gogogo/modules/filemanager")
+
 type FileManager struct {
 	fileAccess *fileaccess.FileAccess
 	cache      *cache.Cache
 	coalescer  *coalescer.Coalescer
 	router     *router.Router
 	rootDir    string
-	GetContent func(path string) ([]byte, error)
+	GetContent func(ctx context.Context, path string) ([]byte, error)
 	OpenFile   func(path string) (*os.File, error)
 	Exists     func(path string) bool
+	List       func(dir string) ([]string, error)
+	Resolve    func(dir string) (string, router.Params, error)
+	Info       func(path string) (*router.FileInfo, error)
 }
 
 type Config struct {
@@ -46,33 +59,67 @@ func New(fa *fileaccess.FileAccess, ca *cache.Cache, co *coalescer.Coalescer, cf
 		fm.GetContent = fm.getProduction
 		fm.Exists = fm.ExistsProduction
 		fm.OpenFile = fm.OpenProduction
+		fm.List = fm.ListProduction
+		fm.Resolve = fm.ResolveProduction
+		fm.Info = fm.InfoProduction
 	} else {
 		fm.GetContent = fm.getDevelopment
 		fm.Exists = fm.ExistsDevelopment
 		fm.OpenFile = fm.OpenDevelopment
+		fm.List = fm.ListDevelopment
+		fm.Resolve = fm.ResolveDevelopment
+		fm.Info = fm.InfoDevelopment
 	}
 
 	return fm
 }
 
-func (fm *FileManager) getDevelopment(path string) ([]byte, error) {
+func (fm *FileManager) getDevelopment(ctx context.Context, path string) (data []byte, err error) {
+	_, span := tracer.Start(ctx, "filemanager.Get", trace.WithAttributes(attribute.String("file.path", path)))
+	defer func() { endGet(span, err) }()
+
 	return fm.fileAccess.Read(filepath.Join(fm.rootDir, path))
 }
 
-func (fm *FileManager) getProduction(path string) ([]byte, error) {
+// endGet ends the span of a read. Pages probe for optional files, so missing
+// ones are noted rather than marked as failures.
+func endGet(span trace.Span, err error) {
+	if errors.Is(err, ErrNotFound) || errors.Is(err, os.ErrNotExist) {
+		span.SetAttributes(attribute.Bool("file.found", false))
+		err = nil
+	}
+	tracing.End(span, err)
+}
+
+// getProduction reads the routed file through the cache, recording cache hits
+// and coalesced reads for the request in ctx
+func (fm *FileManager) getProduction(ctx context.Context, path string) (data []byte, err error) {
+	ctx, span := tracer.Start(ctx, "filemanager.Get", trace.WithAttributes(attribute.String("file.path", path)))
+	defer func() { endGet(span, err) }()
+
+	_, routeSpan := tracer.Start(ctx, "router.Route")
 	distPath, ok := fm.router.Route(path)
+	routeSpan.End()
 	if !ok {
 		return nil, ErrNotFound
 	}
+	span.SetAttributes(attribute.String("file.dist_path", distPath))
 
-	return fm.coalescer.Do(distPath, func() ([]byte, error) {
+	info := reqinfo.FromContext(ctx)
+	read := func(ctx context.Context) ([]byte, error) {
 		if fm.cache != nil {
-			if data, ok := fm.cache.Get(distPath); ok {
+			data, ok := fm.cache.Get(distPath)
+			info.CacheLookup(ok)
+			span.SetAttributes(attribute.Bool("cache.hit", ok))
+			if ok {
 				return data, nil
 			}
 		}
 
+		_, readSpan := tracer.Start(ctx, "file.Read")
 		data, err := fm.fileAccess.Read(distPath)
+		readSpan.SetAttributes(attribute.Int("file.size", len(data)))
+		tracing.End(readSpan, err)
 		if err != nil {
 			return nil, err
 		}
@@ -82,12 +129,21 @@ func (fm *FileManager) getProduction(path string) ([]byte, error) {
 		}
 
 		return data, nil
-	})
+	}
+
+	if fm.coalescer == nil {
+		return read(ctx)
+	}
+	data, err, shared := fm.coalescer.Do(ctx, distPath, read)
+	if shared {
+		info.Coalesced()
+	}
+	return data, err
 }
 
 // OpenFile opens a file for direct reading (used by ServeContent)
 func (fm *FileManager) OpenDevelopment(path string) (*os.File, error) {
-	return fm.fileAccess.Open(path)
+	return fm.fileAccess.Open(filepath.Join(fm.rootDir, path))
 }
 
 func (fm *FileManager) OpenProduction(path string) (*os.File, error) {
@@ -107,3 +163,144 @@ func (fm *FileManager) ExistsProduction(path string) bool {
 	_, ok := fm.router.Route(path)
 	return ok
 }
+
+// Info describes a file, in production with the size, ETag and content type
+// recorded by the build
+func (fm *FileManager) InfoDevelopment(path string) (*router.FileInfo, error) {
+	stat, err := fm.fileAccess.Stat(filepath.Join(fm.rootDir, path))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	if stat.IsDir() {
+		return nil, ErrNotFound
+	}
+
+	return &router.FileInfo{
+		ModTime:     stat.ModTime(),
+		Size:        stat.Size(),
+		ContentType: mime.TypeByExtension(filepath.Ext(path)),
+	}, nil
+}
+
+func (fm *FileManager) InfoProduction(path string) (*router.FileInfo, error) {
+	info, ok := fm.router.Info(path)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return info, nil
+}
+
+// BuildID identifies the build served in production, empty in development
+func (fm *FileManager) BuildID() string {
+	if fm.router == nil {
+		return ""
+	}
+	return fm.router.BuildID()
+}
+
+// List returns the file names directly inside dir
+func (fm *FileManager) ListDevelopment(dir string) ([]string, error) {
+	entries, err := fm.fileAccess.ReadDir(filepath.Join(fm.rootDir, dir))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			names = append(names, entry.Name())
+		}
+	}
+	return names, nil
+}
+
+func (fm *FileManager) ListProduction(dir string) ([]string, error) {
+	names, ok := fm.router.List(dir)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return names, nil
+}
+
+// Resolve maps a requested directory onto the directory that serves it,
+// matching [slug] and [...rest] directories against the request segments.
+// Files inside the returned directory can be looked up directly.
+func (fm *FileManager) ResolveDevelopment(dir string) (string, router.Params, error) {
+	if info, err := fm.fileAccess.Stat(filepath.Join(fm.rootDir, dir)); err == nil && info.IsDir() {
+		return dir, nil, nil
+	}
+
+	var segments []string
+	for _, segment := range strings.Split(dir, "/") {
+		if segment != "" {
+			segments = append(segments, segment)
+		}
+	}
+
+	params := make(router.Params, 2)
+	resolved, ok := fm.resolveDir("", segments, params)
+	if !ok {
+		return "", nil, ErrNotFound
+	}
+	return resolved, params, nil
+}
+
+func (fm *FileManager) resolveDir(dir string, segments []string, params router.Params) (string, bool) {
+	if len(segments) == 0 {
+		return dir, true
+	}
+
+	literal := filepath.Join(dir, segments[0])
+	if info, err := fm.fileAccess.Stat(filepath.Join(fm.rootDir, literal)); err == nil && info.IsDir() {
+		if resolved, ok := fm.resolveDir(literal, segments[1:], params); ok {
+			return resolved, true
+		}
+	}
+
+	entries, err := fm.fileAccess.ReadDir(filepath.Join(fm.rootDir, dir))
+	if err != nil {
+		return "", false
+	}
+
+	// Same precedence as the production router, :param before *catchall
+	for _, prefix := range []byte{':', '*'} {
+		for _, entry := range entries {
+			segment, ok := router.DynamicSegment(entry.Name())
+			if !ok || !entry.IsDir() || segment[0] != prefix {
+				continue
+			}
+
+			next := filepath.Join(dir, entry.Name())
+			if prefix == ':' {
+				if resolved, ok := fm.resolveDir(next, segments[1:], params); ok {
+					params[segment[1:]] = segments[0]
+					return resolved, true
+				}
+				continue
+			}
+
+			for n := len(segments); n > 0; n-- {
+				if resolved, ok := fm.resolveDir(next, segments[n:], params); ok {
+					params[segment[1:]] = strings.Join(segments[:n], "/")
+					return resolved, true
+				}
+			}
+		}
+	}
+
+	return "", false
+}
+
+func (fm *FileManager) ResolveProduction(dir string) (string, router.Params, error) {
+	pattern, params, ok := fm.router.Match(dir)
+	if !ok {
+		return "", nil, ErrNotFound
+	}
+	return pattern, params, nil
+}
@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"gogogo/modules/config"
+)
+
+// CacheControl picks the Cache-Control header of a response by URL prefix
+type CacheControl struct {
+	policies []config.CachePolicy
+}
+
+func NewCacheControl(policies []config.CachePolicy) *CacheControl {
+	sorted := make([]config.CachePolicy, len(policies))
+	copy(sorted, policies)
+
+	// Longest prefix first so the first match is the most specific
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return len(sorted[i].Prefix) > len(sorted[j].Prefix)
+	})
+
+	return &CacheControl{policies: sorted}
+}
+
+// Set writes the header of the policy matching the request, its hashed
+// variant when the URL names a fingerprinted file or the current build.
+// A nil CacheControl, as used in development, sets nothing.
+func (cc *CacheControl) Set(w http.ResponseWriter, r *http.Request, hashed bool) {
+	if cc == nil {
+		return
+	}
+
+	path := requestPath(r)
+	for _, policy := range cc.policies {
+		if !strings.HasPrefix(path, policy.Prefix) {
+			continue
+		}
+
+		value := policy.Policy
+		if hashed && policy.Hashed != "" {
+			value = policy.Hashed
+		}
+		if value != "" {
+			w.Header().Set("Cache-Control", value)
+		}
+		return
+	}
+}
+
+// requestPath is the path the client asked for, before any prefix stripping
+func requestPath(r *http.Request) string {
+	if r.RequestURI != "" {
+		if u, err := url.ParseRequestURI(r.RequestURI); err == nil {
+			return u.Path
+		}
+	}
+	return r.URL.Path
+}
@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"gogogo/modules/filemanager"
+	"gogogo/modules/logger"
+	"gogogo/modules/pages"
+	"gogogo/modules/server"
+	"gogogo/modules/templates"
+)
+
+// ErrorPages answers failed requests with the error page for their status,
+// pre-rendered by the build in production and rendered per request in
+// development. SPA requests get the page as JSON.
+type ErrorPages struct {
+	fm              *filemanager.FileManager
+	templates       *templates.TemplateEngine
+	defaultTemplate string
+	contentPath     string
+	spaPrefix       string
+	SPAMode         bool
+	ProductionMode  bool
+}
+
+func NewErrorPages(fm *filemanager.FileManager, te *templates.TemplateEngine, defaultTemplate string, contentPath string, spaPrefix string, SPAMode bool, productionMode bool) *ErrorPages {
+	return &ErrorPages{
+		fm:              fm,
+		templates:       te,
+		defaultTemplate: defaultTemplate,
+		contentPath:     contentPath,
+		spaPrefix:       spaPrefix,
+		SPAMode:         SPAMode,
+		ProductionMode:  productionMode,
+	}
+}
+
+// ServeError writes the error page for status, falling back to plain text
+// when there is none
+func (e *ErrorPages) ServeError(w http.ResponseWriter, r *http.Request, status int) {
+	// Whatever the failed handler prepared no longer applies
+	for _, name := range []string{"Cache-Control", "Content-Encoding", "Content-Length", "ETag", "Vary"} {
+		w.Header().Del(name)
+	}
+	w.Header().Set("Cache-Control", "no-store")
+
+	dir := e.contentPath + "/" + pages.ErrorsDir + "/" + strconv.Itoa(status)
+	if e.spaPrefix != "" && strings.HasPrefix(requestPath(r), e.spaPrefix) {
+		e.serveJSON(w, r, status, dir)
+		return
+	}
+
+	if e.ProductionMode {
+		if content, err := e.fm.GetContent(r.Context(), dir+"/"+pages.PageFile); err == nil {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.WriteHeader(status)
+			w.Write(content)
+			return
+		}
+	}
+
+	if page, err := e.render(r, dir); err == nil {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(status)
+		w.Write(page)
+		return
+	} else if err != filemanager.ErrNotFound {
+		logger.FromContext(r.Context()).Error("Error page failed to render", "status", status, "error", err)
+	}
+
+	http.Error(w, http.StatusText(status), status)
+}
+
+// render renders the error page in dir through its template
+func (e *ErrorPages) render(r *http.Request, dir string) ([]byte, error) {
+	pc := loadContent(r.Context(), e.fm, dir)
+	if pc.err != nil {
+		return nil, filemanager.ErrNotFound
+	}
+
+	tmpl, err := e.templates.GetTemplate(r.Context(), pageTemplate(pc.meta, e.defaultTemplate))
+	if err != nil {
+		return nil, err
+	}
+
+	page, err := executePage(tmpl, r, pc, e.SPAMode)
+	if err != nil {
+		return nil, err
+	}
+	if !e.ProductionMode {
+		page = server.InjectLiveReload(page)
+	}
+	return page, nil
+}
+
+func (e *ErrorPages) serveJSON(w http.ResponseWriter, r *http.Request, status int, dir string) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if e.ProductionMode {
+		if content, err := e.fm.GetContent(r.Context(), dir+"/"+pages.SPAFile); err == nil {
+			w.WriteHeader(status)
+			w.Write(content)
+			return
+		}
+	}
+
+	resp := pages.SPAResponse{
+		Error:     &pages.SPAError{Status: status, Message: http.StatusText(status)},
+		IsSPAMode: e.SPAMode,
+	}
+	if pc := loadContent(r.Context(), e.fm, dir); pc.err == nil {
+		resp.Content = string(pc.content)
+		resp.Style = string(pc.style)
+		resp.Script = string(pc.script)
+		resp.StyleURL = pc.styleExists
+		resp.ScriptURL = pc.scriptExists
+		resp.Meta = pc.meta
+	}
+
+	w.WriteHeader(status)
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	encoder.Encode(resp)
+}
@@ -1,26 +1,49 @@
 package handlers
 
 import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
 	"encoding/json"
+	"fmt"
 	"html/template"
 	"net/http"
+	"path"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"sync"
 
+	"gogogo/modules/compression"
 	"gogogo/modules/filemanager"
+	"gogogo/modules/logger"
+	"gogogo/modules/markdown"
 	"gogogo/modules/metaparser"
+	"gogogo/modules/pages"
+	"gogogo/modules/reqinfo"
+	"gogogo/modules/router"
+	"gogogo/modules/server"
+	"gogogo/modules/templates"
+	"gogogo/modules/tracing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // Pre-computed paths
 const (
-	contentFile = "content.html"
-	metaFile    = "meta.toml"
-	styleFile   = "style.css"
-	scriptFile  = "script.js"
+	contentFile  = "content.html"
+	markdownFile = "content.md"
+	metaFile     = "meta.toml"
+	styleFile    = "style.css"
+	scriptFile   = "script.js"
 )
 
 var defaultMeta = &metaparser.MetaData{}
 
+var tracer = tracing.Tracer("gogogo/modules/handlers")
+
 type PageData struct {
 	content      []byte
 	style        []byte
@@ -32,20 +55,29 @@ type PageData struct {
 }
 
 type WebHandler struct {
-	fm          *filemanager.FileManager
-	template    *template.Template
-	contentPath string
-	SPAMode     bool
+	fm              *filemanager.FileManager
+	templates       *templates.TemplateEngine
+	defaultTemplate string
+	contentPath     string
+	cache           *CacheControl
+	errors          *ErrorPages
+	SPAMode         bool
+	ProductionMode  bool
 }
 
 type SPAHandler struct {
-	fm          *filemanager.FileManager
-	contentPath string
-	SPAMode     bool
+	fm             *filemanager.FileManager
+	contentPath    string
+	cache          *CacheControl
+	errors         *ErrorPages
+	SPAMode        bool
+	ProductionMode bool
 }
 
 type StaticHandler struct {
-	fm *filemanager.FileManager
+	fm     *filemanager.FileManager
+	cache  *CacheControl
+	errors *ErrorPages
 }
 
 type APIHandler struct {
@@ -53,25 +85,32 @@ type APIHandler struct {
 	contentPath string
 }
 
-func NewWebHandler(fm *filemanager.FileManager, tmpl *template.Template, contentPath string, SPAMode bool) *WebHandler {
+func NewWebHandler(fm *filemanager.FileManager, te *templates.TemplateEngine, defaultTemplate string, contentPath string, cache *CacheControl, errors *ErrorPages, SPAMode bool, productionMode bool) *WebHandler {
 	return &WebHandler{
-		fm:          fm,
-		template:    tmpl,
-		contentPath: contentPath,
-		SPAMode:     SPAMode,
+		fm:              fm,
+		templates:       te,
+		defaultTemplate: defaultTemplate,
+		contentPath:     contentPath,
+		cache:           cache,
+		errors:          errors,
+		SPAMode:         SPAMode,
+		ProductionMode:  productionMode,
 	}
 }
 
-func NewSPAHandler(fm *filemanager.FileManager, contentPath string, SPAMode bool) *SPAHandler {
+func NewSPAHandler(fm *filemanager.FileManager, contentPath string, cache *CacheControl, errors *ErrorPages, SPAMode bool, productionMode bool) *SPAHandler {
 	return &SPAHandler{
-		fm:          fm,
-		contentPath: contentPath,
-		SPAMode:     SPAMode,
+		fm:             fm,
+		contentPath:    contentPath,
+		cache:          cache,
+		errors:         errors,
+		SPAMode:        SPAMode,
+		ProductionMode: productionMode,
 	}
 }
 
-func NewStaticHandler(fm *filemanager.FileManager) *StaticHandler {
-	return &StaticHandler{fm: fm}
+func NewStaticHandler(fm *filemanager.FileManager, cache *CacheControl, errors *ErrorPages) *StaticHandler {
+	return &StaticHandler{fm: fm, cache: cache, errors: errors}
 }
 
 func NewAPIHandler(fm *filemanager.FileManager, contentPath string) *APIHandler {
@@ -81,29 +120,87 @@ func NewAPIHandler(fm *filemanager.FileManager, contentPath string) *APIHandler
 	}
 }
 
-func loadContent(fm *filemanager.FileManager, dir string, path string) *PageData {
-	contentPath := dir + "/" + path + "/" + contentFile
-	metaPath := dir + "/" + path + "/" + metaFile
-	stylePath := dir + "/" + path + "/" + styleFile
-	scriptPath := dir + "/" + path + "/" + scriptFile
+// routed names the request after the route that matched, in its trace and
+// in the per-route metrics
+func routed(ctx context.Context, route string) {
+	tracing.Route(ctx, route)
+	reqinfo.FromContext(ctx).Routed(route)
+}
+
+// resolvePage finds the content directory serving path and stores the values
+// of any dynamic segments in the request context. Error pages are only
+// served through ErrorPages. The request is recorded under its route.
+func resolvePage(fm *filemanager.FileManager, r *http.Request, dir string, path string) (*http.Request, string, error) {
+	if rest := strings.TrimPrefix(path, "/"); rest == pages.ErrorsDir || strings.HasPrefix(rest, pages.ErrorsDir+"/") {
+		return r, "", filemanager.ErrNotFound
+	}
+
+	_, span := tracer.Start(r.Context(), "router.Match", trace.WithAttributes(attribute.String("page.path", path)))
+	pageDir, params, err := fm.Resolve(dir + "/" + path)
+	span.End()
+	if err != nil {
+		return r, "", err
+	}
+
+	// The pattern below the content directory, under the prefix the
+	// request was routed by, e.g. "/__spa__/blog/:slug"
+	route := strings.TrimPrefix(pageDir, dir)
+	if route == "" {
+		route = "/"
+	}
+	routed(r.Context(), strings.TrimSuffix(r.Pattern, "/")+route)
+
+	if params != nil {
+		r = r.WithContext(router.WithParams(r.Context(), params))
+	}
+	return r, pageDir, nil
+}
+
+func loadContent(ctx context.Context, fm *filemanager.FileManager, dir string) *PageData {
+	ctx, span := tracer.Start(ctx, "content.Load", trace.WithAttributes(attribute.String("page.dir", dir)))
+	defer span.End()
+
+	contentPath := dir + "/" + contentFile
+	markdownPath := dir + "/" + markdownFile
+	metaPath := dir + "/" + metaFile
+	stylePath := dir + "/" + styleFile
+	scriptPath := dir + "/" + scriptFile
 
 	pd := &PageData{
 		meta: defaultMeta,
 	}
 
+	var front *metaparser.MetaData
 	var wg sync.WaitGroup
 	wg.Add(2)
 
 	go func() {
 		defer wg.Done()
-		pd.content, pd.err = fm.GetContent(contentPath)
+		pd.content, pd.err = fm.GetContent(ctx, contentPath)
+		if pd.err == nil || !fm.Exists(markdownPath) {
+			return
+		}
+
+		// Markdown pages are rendered on the fly in development, the build
+		// pre-renders them into content.html for production
+		src, err := fm.GetContent(ctx, markdownPath)
+		if err != nil {
+			pd.err = err
+			return
+		}
+		_, renderSpan := tracer.Start(ctx, "markdown.Render")
+		pd.content, front, pd.err = markdown.Render(src)
+		tracing.End(renderSpan, pd.err)
 	}()
 
 	go func() {
 		defer wg.Done()
-		metaContent, err := fm.GetContent(metaPath)
+		metaContent, err := fm.GetContent(ctx, metaPath)
 		if err == nil {
-			if meta, err := metaparser.ParseMetaData(metaContent); err == nil {
+			_, parseSpan := tracer.Start(ctx, "meta.Parse")
+			meta, err := metaparser.ParseMetaData(metaContent)
+			tracing.End(parseSpan, err)
+			if err == nil {
 				pd.meta = meta
 			}
 		}
@@ -111,11 +208,22 @@ func loadContent(fm *filemanager.FileManager, dir string, path string) *PageData
 
 	wg.Wait()
 	if pd.err != nil {
+		span.SetAttributes(attribute.Bool("page.found", false))
 		return pd
 	}
 
+	if info := reqinfo.FromContext(ctx); info != nil {
+		if built, err := fm.Info(contentPath); err == nil {
+			info.ServedFrom(built.DistPath)
+		}
+	}
+
+	if front != nil {
+		pd.meta = pd.meta.Merge(front)
+	}
+
 	if pd.meta.InlineStyle {
-		if style, err := fm.GetContent(stylePath); err == nil {
+		if style, err := fm.GetContent(ctx, stylePath); err == nil {
 			pd.style = style
 		}
 	} else if fm.Exists(stylePath) {
@@ -123,7 +231,7 @@ func loadContent(fm *filemanager.FileManager, dir string, path string) *PageData
 	}
 
 	if pd.meta.InlineScript {
-		if script, err := fm.GetContent(scriptPath); err == nil {
+		if script, err := fm.GetContent(ctx, scriptPath); err == nil {
 			pd.script = script
 		}
 	} else if fm.Exists(scriptPath) {
@@ -134,11 +242,38 @@ func loadContent(fm *filemanager.FileManager, dir string, path string) *PageData
 }
 
 func (h *WebHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx, span := tracer.Start(r.Context(), "handlers.Web")
+	defer span.End()
+	r = r.WithContext(ctx)
+
 	path := r.URL.Path
 
-	pc := loadContent(h.fm, h.contentPath, path)
+	r, dir, err := resolvePage(h.fm, r, h.contentPath, path)
+	if err != nil {
+		h.errors.ServeError(w, r, http.StatusNotFound)
+		return
+	}
+
+	if h.ProductionMode && h.servePage(w, r, dir) {
+		span.SetAttributes(attribute.Bool("page.prebuilt", true))
+		return
+	}
+
+	pc := loadContent(r.Context(), h.fm, dir)
 	if pc.err != nil {
-		http.NotFound(w, r)
+		h.errors.ServeError(w, r, http.StatusNotFound)
+		return
+	}
+
+	name := pageTemplate(pc.meta, h.defaultTemplate)
+	tmpl, err := h.templates.GetTemplate(r.Context(), name)
+	if err != nil {
+		logger.FromContext(r.Context()).Error("Template error", "path", path, "error", err)
+		if !h.ProductionMode {
+			h.overlay(w, fmt.Sprintf("Template %q failed to load", name), err)
+			return
+		}
+		h.errors.ServeError(w, r, http.StatusInternalServerError)
 		return
 	}
 
@@ -162,6 +297,55 @@ func (h *WebHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	page, err := executePage(tmpl, r, pc, h.SPAMode)
+	if err != nil {
+		logger.FromContext(r.Context()).Error("Template error", "path", path, "error", err)
+		if h.ProductionMode {
+			h.errors.ServeError(w, r, http.StatusInternalServerError)
+			return
+		}
+		h.overlay(w, fmt.Sprintf("Template %q failed to render", name), err)
+		return
+	}
+
+	if h.ProductionMode {
+		// Pages rendered per request revalidate against a hash of the output
+		sum := md5.Sum(page)
+		etag := `"` + hex.EncodeToString(sum[:]) + `"`
+		w.Header().Set("ETag", etag)
+		h.cache.Set(w, r, false)
+		if etagMatch(r.Header.Get("If-None-Match"), etag) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Content-Length", strconv.Itoa(len(page)))
+		if r.Method != http.MethodHead {
+			w.Write(page)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(server.InjectLiveReload(page))
+}
+
+// pageTemplate names the layout of a page, set via meta.toml and falling
+// back to the main template
+func pageTemplate(meta *metaparser.MetaData, defaultTemplate string) string {
+	if meta.Template != "" {
+		return meta.Template
+	}
+	return defaultTemplate
+}
+
+// executePage renders a page into a buffer, so execution errors can replace
+// it instead of leaving it half written
+func executePage(tmpl *template.Template, r *http.Request, pc *PageData, SPAMode bool) (_ []byte, err error) {
+	_, span := tracer.Start(r.Context(), "template.Execute", trace.WithAttributes(attribute.String("template.name", tmpl.Name())))
+	defer func() { tracing.End(span, err) }()
+
 	data := struct {
 		Content   template.HTML
 		Style     template.CSS
@@ -169,27 +353,139 @@ func (h *WebHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		StyleURL  string
 		ScriptURL string
 		Meta      *metaparser.MetaData
+		Params    router.Params
 		IsSPAMode bool
 	}{
 		Meta:      pc.meta,
+		Params:    router.ParamsFromContext(r.Context()),
 		Content:   template.HTML(pc.content),
 		Style:     template.CSS(pc.style),
 		Script:    template.JS(pc.script),
 		StyleURL:  pc.styleExists,
 		ScriptURL: pc.scriptExists,
-		IsSPAMode: h.SPAMode,
+		IsSPAMode: SPAMode,
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// servePage writes the page the build pre-rendered for dir, reporting false
+// when there is none, e.g. for dynamic routes rendered per request
+func (h *WebHandler) servePage(w http.ResponseWriter, r *http.Request, dir string) bool {
+	h.cache.Set(w, r, false)
+	if servePrebuilt(h.fm, w, r, dir+"/"+pages.PageFile, "text/html; charset=utf-8") {
+		return true
+	}
+	w.Header().Del("Cache-Control")
+	return false
+}
+
+// servePrebuilt streams a file written by the build with the size, ETag and
+// content type it recorded, reporting false when the build has no such file.
+// The best precompressed variant the client accepts is sent instead if any.
+func servePrebuilt(fm *filemanager.FileManager, w http.ResponseWriter, r *http.Request, path string, contentType string) bool {
+	info, err := fm.Info(path)
+	if err != nil {
+		return false
+	}
+
+	source, etag, encoding := path, info.ETag, ""
+	if len(info.Encodings) > 0 {
+		w.Header().Add("Vary", "Accept-Encoding")
+		if encoding = negotiateEncoding(r, info); encoding != "" {
+			source = path + compression.Extension(encoding)
+			etag = compression.VariantETag(info.ETag, encoding)
+		}
+	}
+
+	if etag != "" {
+		w.Header().Set("ETag", etag)
+		if etagMatch(r.Header.Get("If-None-Match"), etag) {
+			w.WriteHeader(http.StatusNotModified)
+			return true
+		}
+	}
+
+	content, err := fm.GetContent(r.Context(), source)
+	if err != nil {
+		logger.FromContext(r.Context()).Error("Pre-built file failed to load", "path", source, "error", err)
+		w.Header().Del("ETag")
+		w.Header().Del("Vary")
+		return false
+	}
+
+	reqinfo.FromContext(r.Context()).ServedFrom(info.DistPath + compression.Extension(encoding))
+
+	if info.ContentType != "" {
+		contentType = info.ContentType
+	}
+	w.Header().Set("Content-Type", contentType)
+	if encoding != "" {
+		w.Header().Set("Content-Encoding", encoding)
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+	if r.Method != http.MethodHead {
+		w.Write(content)
+	}
+	return true
+}
+
+// negotiateEncoding picks the precompressed variant of info to answer r with,
+// "" for the original
+func negotiateEncoding(r *http.Request, info *router.FileInfo) string {
+	return compression.Negotiate(r.Header.Get("Accept-Encoding"), func(encoding string) bool {
+		_, ok := info.Encodings[encoding]
+		return ok
+	})
+}
+
+// etagMatch reports whether an If-None-Match header lists etag
+func etagMatch(header string, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == etag || candidate == "*" {
+			return true
+		}
 	}
+	return false
+}
 
+// overlay shows a development error page that reloads once the error is fixed
+func (h *WebHandler) overlay(w http.ResponseWriter, title string, err error) {
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	h.template.Execute(w, data)
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusInternalServerError)
+	w.Write(server.InjectLiveReload(templates.ErrorOverlay(title, err)))
 }
 
 func (h *SPAHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx, span := tracer.Start(r.Context(), "handlers.SPA")
+	defer span.End()
+	r = r.WithContext(ctx)
+
 	path := r.URL.Path
 
-	pc := loadContent(h.fm, h.contentPath, path)
+	r, dir, err := resolvePage(h.fm, r, h.contentPath, path)
+	if err != nil {
+		h.errors.ServeError(w, r, http.StatusNotFound)
+		return
+	}
+
+	if h.ProductionMode {
+		h.cacheHeaders(w, r)
+		if servePrebuilt(h.fm, w, r, dir+"/"+pages.SPAFile, "application/json") {
+			span.SetAttributes(attribute.Bool("page.prebuilt", true))
+			return
+		}
+	}
+
+	pc := loadContent(r.Context(), h.fm, dir)
 	if pc.err != nil {
-		http.NotFound(w, r)
+		h.errors.ServeError(w, r, http.StatusNotFound)
 		return
 	}
 
@@ -213,17 +509,9 @@ func (h *SPAHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	// Reuse buffer for response
-	resp := struct {
-		Content   string               `json:"Content"`
-		Style     string               `json:"Style,omitempty"`
-		Script    string               `json:"Script,omitempty"`
-		StyleURL  string               `json:"StyleUrl,omitempty"`
-		ScriptURL string               `json:"ScriptUrl,omitempty"`
-		Meta      *metaparser.MetaData `json:"Meta,omitempty"`
-		IsSPAMode bool
-	}{
+	resp := pages.SPAResponse{
 		Meta:      pc.meta,
+		Params:    router.ParamsFromContext(r.Context()),
 		Content:   string(pc.content),
 		Style:     string(pc.style),
 		Script:    string(pc.script),
@@ -238,34 +526,80 @@ func (h *SPAHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	encoder.Encode(resp)
 }
 
+// cacheHeaders applies the hashed cache policy to SPA payloads asked for by
+// the current build ID, the X-Build-ID header tells clients which it is.
+// Unversioned or outdated requests get the regular policy and the ETag.
+func (h *SPAHandler) cacheHeaders(w http.ResponseWriter, r *http.Request) {
+	buildID := h.fm.BuildID()
+	if buildID == "" {
+		return
+	}
+
+	w.Header().Set("X-Build-ID", buildID)
+	h.cache.Set(w, r, r.URL.Query().Get("v") == buildID)
+}
+
 func (h *StaticHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx, span := tracer.Start(r.Context(), "handlers.Static")
+	defer span.End()
+	r = r.WithContext(ctx)
+	routed(ctx, r.Pattern+"*")
+
+	_, openSpan := tracer.Start(ctx, "file.Open", trace.WithAttributes(attribute.String("file.path", r.URL.Path)))
 	file, err := h.fm.OpenFile(r.URL.Path)
+	openSpan.End()
 	if err != nil {
-		http.NotFound(w, r)
+		h.errors.ServeError(w, r, http.StatusNotFound)
 		return
 	}
 	defer file.Close()
 
+	// Files the build described carry a strong ETag, ServeContent answers
+	// If-None-Match with it. Their fingerprinted names never change content.
+	if built, err := h.fm.Info(r.URL.Path); err == nil && built.ETag != "" {
+		etag := built.ETag
+		if len(built.Encodings) > 0 {
+			w.Header().Add("Vary", "Accept-Encoding")
+			if encoding := negotiateEncoding(r, built); encoding != "" {
+				if variant, err := h.fm.OpenFile(r.URL.Path + compression.Extension(encoding)); err == nil {
+					defer variant.Close()
+					file = variant
+					etag = compression.VariantETag(built.ETag, encoding)
+					w.Header().Set("Content-Encoding", encoding)
+				}
+			}
+		}
+		w.Header().Set("ETag", etag)
+		h.cache.Set(w, r, path.Base(r.URL.Path) == filepath.Base(built.DistPath))
+	}
+
 	info, err := file.Stat()
 	if err != nil {
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
 
+	reqinfo.FromContext(r.Context()).ServedFrom(file.Name())
 	http.ServeContent(w, r, r.URL.Path, info.ModTime(), file)
 }
 
 func (h *APIHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	path := r.URL.Path[5:] // strip /api/
 
-	content, err := h.fm.GetContent(filepath.Join(h.contentPath, path, contentFile))
+	_, dir, err := resolvePage(h.fm, r, h.contentPath, path)
 	if err != nil {
 		http.NotFound(w, r)
 		return
 	}
 
+	pc := loadContent(r.Context(), h.fm, dir)
+	if pc.err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	encoder := json.NewEncoder(w)
 	encoder.SetEscapeHTML(false)
-	encoder.Encode(map[string]string{"content": string(content)})
+	encoder.Encode(map[string]string{"content": string(pc.content)})
 }
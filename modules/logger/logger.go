@@ -1,113 +1,110 @@
 package logger
 
-// import (
-// 	"go/ast"
-// 	"go/parser"
-// 	"go/token"
-// 	"log"
-// 	"os"
-// 	"strings"
-// )
-
-// type TraceLevel int
-
-// const (
-// 	TraceSilent TraceLevel = iota
-// 	TraceError
-// 	TraceWarn
-// 	TraceInfo
-// 	TraceDebug
-// 	TraceVerbose
-// )
-
-// var currentTraceLevel TraceLevel
-
-// func init() {
-// 	// Set the trace level based on an environment variable
-// 	levelStr := os.Getenv("TRACE_LEVEL")
-// 	switch strings.ToLower(levelStr) {
-// 	case "verbose":
-// 		currentTraceLevel = TraceVerbose
-// 	case "debug":
-// 		currentTraceLevel = TraceDebug
-// 	case "info":
-// 		currentTraceLevel = TraceInfo
-// 	case "warn":
-// 		currentTraceLevel = TraceWarn
-// 	case "error":
-// 		currentTraceLevel = TraceError
-// 	default:
-// 		currentTraceLevel = TraceSilent
-// 	}
-// }
-
-// func InjectTracing(filename string) error {
-// 	fset := token.NewFileSet()
-// 	node, err := parser.ParseFile(fset, filename, nil, parser.ParseComments)
-// 	if err != nil {
-// 		return err
-// 	}
-
-// 	ast.Inspect(node, func(n ast.Node) bool {
-// 		if fn, ok := n.(*ast.FuncDecl); ok {
-// 			injectFunctionTracing(fn)
-// 		}
-// 		return true
-// 	})
-
-// 	// Write the modified AST back to a file
-// 	// (Implementation omitted for brevity)
-
-// 	return nil
-// }
-
-// func injectFunctionTracing(fn *ast.FuncDecl) {
-// 	for _, comment := range fn.Doc.List {
-// 		if strings.HasPrefix(comment.Text, "// @Trace") {
-// 			level := extractTraceLevel(comment.Text)
-// 			injectTraceStatement(fn, level)
-// 		}
-// 		if strings.Contains(comment.Text, "@ErrorHandle") {
-// 			injectErrorHandling(fn)
-// 		}
-// 		if strings.Contains(comment.Text, "@Profile") {
-// 			injectProfiling(fn)
-// 		}
-// 	}
-// }
-
-// func extractTraceLevel(comment string) TraceLevel {
-// 	// Extract trace level from comment
-// 	// Implementation omitted for brevity
-// 	return TraceInfo
-// }
-
-// func injectTraceStatement(fn *ast.FuncDecl, level TraceLevel) {
-// 	// Inject tracing at the start of the function
-// 	// If level is TraceVerbose, inject tracing for all parameters
-// 	// and variable assignments within the function
-// }
-
-// func injectErrorHandling(fn *ast.FuncDecl) {
-// 	// Wrap the function body in a defer-recover block
-// }
-
-// func injectProfiling(fn *ast.FuncDecl) {
-// 	// Inject code to measure function execution time and memory usage
-// }
-
-// // Usage example:
-// // @Trace(Level=Verbose)
-// // @ErrorHandle
-// // @Profile
-// func SomeFunction(param1 int, param2 string) error {
-// 	result := param1 + len(param2)
-// 	return nil
-// }
-
-// func main() {
-// 	err := InjectTracing("your_file.go")
-// 	if err != nil {
-// 		log.Fatalf("Failed to inject tracing: %v", err)
-// 	}
-// }
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+)
+
+type Config struct {
+	Level   string // "debug", "info", "warn" or "error"
+	Format  string // "text" or "json"
+	File    string // Empty logs to stderr only
+	Console bool   // Also log to stderr when logging to a file
+
+	// File rotation, zero values disable each rule
+	MaxSize     int           // Megabytes before the file is rotated
+	RotateEvery time.Duration // Age of the file before it is rotated
+	MaxBackups  int           // Rotated files kept
+	MaxAge      time.Duration // Rotated files older than this are removed
+}
+
+type contextKey struct{}
+
+// New creates a logger writing as configured. The returned closer releases
+// the log file, if any.
+func New(cfg Config) (*slog.Logger, io.Closer, error) {
+	level, err := ParseLevel(cfg.Level)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var out io.Writer = os.Stderr
+	var closer io.Closer = nopCloser{}
+	if cfg.File != "" {
+		file, err := OpenRotating(cfg.File, cfg.MaxSize, cfg.RotateEvery, cfg.MaxBackups, cfg.MaxAge)
+		if err != nil {
+			return nil, nil, err
+		}
+		out, closer = file, file
+		if cfg.Console {
+			out = io.MultiWriter(os.Stderr, file)
+		}
+	}
+
+	opts := &slog.HandlerOptions{Level: level}
+	var handler slog.Handler
+	switch strings.ToLower(cfg.Format) {
+	case "", "text":
+		handler = slog.NewTextHandler(out, opts)
+	case "json":
+		handler = slog.NewJSONHandler(out, opts)
+	default:
+		closer.Close()
+		return nil, nil, fmt.Errorf("unknown log format %q", cfg.Format)
+	}
+
+	return slog.New(handler), closer, nil
+}
+
+// Setup makes a logger as configured the default, which the standard log
+// package also writes through
+func Setup(cfg Config) (io.Closer, error) {
+	l, closer, err := New(cfg)
+	if err != nil {
+		return nil, err
+	}
+	slog.SetDefault(l)
+	return closer, nil
+}
+
+func ParseLevel(level string) (slog.Level, error) {
+	switch strings.ToLower(level) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "", "info":
+		return slog.LevelInfo, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+	return slog.LevelInfo, fmt.Errorf("unknown log level %q", level)
+}
+
+// WithContext stores a request-scoped logger in ctx
+func WithContext(ctx context.Context, l *slog.Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, l)
+}
+
+// FromContext returns the logger stored in ctx, the default one without
+func FromContext(ctx context.Context) *slog.Logger {
+	if l, ok := ctx.Value(contextKey{}).(*slog.Logger); ok {
+		return l
+	}
+	return slog.Default()
+}
+
+// Fatal logs at error level and exits, for failures during startup
+func Fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+type nopCloser struct{}
+
+func (nopCloser) Close() error { return nil }
@@ -0,0 +1,164 @@
+package logger
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const backupTimeFormat = "20060102T150405.000"
+
+// RotatingFile is a log file that is moved aside once it grows past a size
+// or age, keeping a limited number of rotated files next to it
+type RotatingFile struct {
+	mu         sync.Mutex
+	path       string
+	maxSize    int64
+	every      time.Duration
+	maxBackups int
+	maxAge     time.Duration
+
+	file   *os.File
+	size   int64
+	opened time.Time
+}
+
+// OpenRotating opens path for appending. Files are rotated past maxSizeMB
+// megabytes or every interval, rotated files beyond maxBackups or older than
+// maxAge are removed. Zero values disable each rule.
+func OpenRotating(path string, maxSizeMB int, every time.Duration, maxBackups int, maxAge time.Duration) (*RotatingFile, error) {
+	f := &RotatingFile{
+		path:       path,
+		maxSize:    int64(maxSizeMB) * 1024 * 1024,
+		every:      every,
+		maxBackups: maxBackups,
+		maxAge:     maxAge,
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create log directory: %w", err)
+	}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == nil {
+		return 0, os.ErrClosed
+	}
+	var rotateErr error
+	if f.shouldRotate(len(p)) {
+		// A failed rotation is retried on the next write, until then lines
+		// go to the current file
+		if rotateErr = f.rotate(); f.file == nil {
+			return 0, rotateErr
+		}
+	}
+
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	if err == nil {
+		err = rotateErr
+	}
+	return n, err
+}
+
+func (f *RotatingFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
+
+func (f *RotatingFile) shouldRotate(n int) bool {
+	if f.size == 0 {
+		return false
+	}
+	if f.maxSize > 0 && f.size+int64(n) > f.maxSize {
+		return true
+	}
+	return f.every > 0 && time.Since(f.opened) >= f.every
+}
+
+func (f *RotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to stat log file: %w", err)
+	}
+
+	f.file = file
+	f.size = info.Size()
+	f.opened = time.Now()
+	return nil
+}
+
+// rotate moves the current file aside as name-<time>.ext and starts a new one.
+// If the move fails the current file is opened again. f.file is nil only when
+// no file could be opened.
+func (f *RotatingFile) rotate() error {
+	closeErr := f.file.Close()
+	f.file = nil
+
+	ext := filepath.Ext(f.path)
+	backup := fmt.Sprintf("%s-%s%s", strings.TrimSuffix(f.path, ext), time.Now().Format(backupTimeFormat), ext)
+	if err := os.Rename(f.path, backup); err != nil {
+		return errors.Join(fmt.Errorf("failed to rotate log file: %w", err), f.open())
+	}
+
+	if err := f.open(); err != nil {
+		return err
+	}
+	f.removeBackups()
+	if closeErr != nil {
+		return fmt.Errorf("failed to close log file: %w", closeErr)
+	}
+	return nil
+}
+
+// removeBackups enforces the retention rules on rotated files
+func (f *RotatingFile) removeBackups() {
+	if f.maxBackups <= 0 && f.maxAge <= 0 {
+		return
+	}
+
+	ext := filepath.Ext(f.path)
+	backups, err := filepath.Glob(strings.TrimSuffix(f.path, ext) + "-*" + ext)
+	if err != nil {
+		return
+	}
+
+	// Newest first, the timestamps in the names sort by time
+	sort.Sort(sort.Reverse(sort.StringSlice(backups)))
+	for i, backup := range backups {
+		expired := f.maxBackups > 0 && i >= f.maxBackups
+		if !expired && f.maxAge > 0 {
+			if info, err := os.Stat(backup); err == nil && time.Since(info.ModTime()) > f.maxAge {
+				expired = true
+			}
+		}
+		if expired {
+			os.Remove(backup)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package logger
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRotateRenameFailure(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.log")
+	f, err := OpenRotating(path, 0, 0, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	f.maxSize = 1
+
+	if _, err := f.Write([]byte("first\n")); err != nil {
+		t.Fatalf("first write: %v", err)
+	}
+
+	// With the file gone there is nothing to rename, rotation fails
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := f.Write([]byte("second\n")); err == nil || n != len("second\n") {
+		t.Fatalf("write during failed rotation = %d, %v, want the line written and an error", n, err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "second\n" {
+		t.Errorf("reopened file holds %q, want %q", data, "second\n")
+	}
+
+	// The next write rotates as usual
+	if _, err := f.Write([]byte("third\n")); err != nil {
+		t.Fatalf("write after failed rotation: %v", err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "third\n" {
+		t.Errorf("log file holds %q, want %q", data, "third\n")
+	}
+	backups, _ := filepath.Glob(filepath.Join(dir, "app-*.log"))
+	if len(backups) != 1 {
+		t.Fatalf("backups = %v, want one", backups)
+	}
+	if data, _ := os.ReadFile(backups[0]); string(data) != "second\n" {
+		t.Errorf("backup holds %q, want %q", data, "second\n")
+	}
+}
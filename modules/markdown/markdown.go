@@ -0,0 +1,82 @@
+package markdown
+
+import (
+	"bytes"
+	"fmt"
+
+	"gogogo/modules/metaparser"
+
+	chromahtml "github.com/alecthomas/chroma/v2/formatters/html"
+	"github.com/yuin/goldmark"
+	highlighting "github.com/yuin/goldmark-highlighting/v2"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer/html"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+var md = goldmark.New(
+	goldmark.WithExtensions(
+		extension.GFM, // Tables, strikethrough, autolinks and task lists
+		highlighting.NewHighlighting(
+			highlighting.WithStyle("github"),
+			highlighting.WithFormatOptions(chromahtml.TabWidth(4)),
+		),
+	),
+	goldmark.WithParserOptions(
+		parser.WithAutoHeadingID(),
+		parser.WithASTTransformers(util.Prioritized(headingAnchors{}, 100)),
+	),
+	// Content is written by trusted authors, allow inline HTML
+	goldmark.WithRendererOptions(html.WithUnsafe()),
+)
+
+// Render converts Markdown to HTML. Front matter, when present, is parsed and
+// returned separately, otherwise the returned meta is nil.
+func Render(src []byte) ([]byte, *metaparser.MetaData, error) {
+	front, format, body := metaparser.SplitFrontMatter(src)
+
+	var meta *metaparser.MetaData
+	if format != "" {
+		var err error
+		if meta, err = metaparser.ParseFrontMatter(format, front); err != nil {
+			return nil, nil, fmt.Errorf("error parsing front matter: %w", err)
+		}
+	}
+
+	var buf bytes.Buffer
+	buf.Grow(len(body) * 2)
+	if err := md.Convert(body, &buf); err != nil {
+		return nil, nil, fmt.Errorf("error rendering markdown: %w", err)
+	}
+
+	return buf.Bytes(), meta, nil
+}
+
+// headingAnchors appends a self link to every heading with an id
+type headingAnchors struct{}
+
+func (headingAnchors) Transform(doc *ast.Document, reader text.Reader, pc parser.Context) {
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		heading, ok := n.(*ast.Heading)
+		if !entering || !ok {
+			return ast.WalkContinue, nil
+		}
+
+		id, ok := heading.AttributeString("id")
+		if !ok {
+			return ast.WalkSkipChildren, nil
+		}
+
+		anchor := ast.NewLink()
+		anchor.Destination = append([]byte("#"), id.([]byte)...)
+		anchor.SetAttributeString("class", []byte("anchor"))
+		anchor.AppendChild(anchor, ast.NewString([]byte("#")))
+		heading.AppendChild(heading, ast.NewString([]byte(" ")))
+		heading.AppendChild(heading, anchor)
+
+		return ast.WalkSkipChildren, nil
+	})
+}
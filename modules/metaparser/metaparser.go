@@ -1,19 +1,27 @@
 package metaparser
 
 import (
+	"bytes"
+	"fmt"
 	"html/template"
 
 	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	FormatTOML = "toml"
+	FormatYAML = "yaml"
 )
 
 type MetaData struct {
-    Template     string                 `toml:"template"`
-    InlineStyle  bool                   `toml:"inlineStyle"`
-    InlineScript bool                   `toml:"inlineScript"`
-    Head         []template.HTML        `toml:"head"`
-    CSSImports   []string              `toml:"cssImports"`
-    JSImports    []string              `toml:"jsImports"`
-    Variables    map[string]interface{} `toml:"variables"`
+    Template     string                 `toml:"template" yaml:"template"`
+    InlineStyle  bool                   `toml:"inlineStyle" yaml:"inlineStyle"`
+    InlineScript bool                   `toml:"inlineScript" yaml:"inlineScript"`
+    Head         []template.HTML        `toml:"head" yaml:"head"`
+    CSSImports   []string              `toml:"cssImports" yaml:"cssImports"`
+    JSImports    []string              `toml:"jsImports" yaml:"jsImports"`
+    Variables    map[string]interface{} `toml:"variables" yaml:"variables"`
 }
 
 // ParseMetaData parses TOML metadata into a MetaData pointer
@@ -22,3 +30,110 @@ func ParseMetaData(data []byte) (*MetaData, error) {
     err := toml.Unmarshal(data, meta)
     return meta, err
 }
+
+// SplitFrontMatter separates a leading front matter block from the body.
+// TOML front matter is fenced by +++ lines, YAML front matter by --- lines.
+func SplitFrontMatter(src []byte) (front []byte, format string, body []byte) {
+    var fence []byte
+    switch {
+    case bytes.HasPrefix(src, []byte("+++")):
+        fence, format = []byte("+++"), FormatTOML
+    case bytes.HasPrefix(src, []byte("---")):
+        fence, format = []byte("---"), FormatYAML
+    default:
+        return nil, "", src
+    }
+
+    rest := src[len(fence):]
+    nl := bytes.IndexByte(rest, '\n')
+    if nl == -1 || len(bytes.TrimSpace(rest[:nl])) != 0 {
+        return nil, "", src
+    }
+    rest = rest[nl+1:]
+
+    for offset := 0; offset < len(rest); {
+        end := bytes.IndexByte(rest[offset:], '\n')
+        line := rest[offset:]
+        if end != -1 {
+            line = rest[offset : offset+end]
+        }
+
+        if bytes.Equal(bytes.TrimRight(line, " \t\r"), fence) {
+            body = nil
+            if end != -1 {
+                body = rest[offset+end+1:]
+            }
+            return rest[:offset], format, body
+        }
+
+        if end == -1 {
+            break
+        }
+        offset += end + 1
+    }
+
+    // Unterminated fence, treat everything as body
+    return nil, "", src
+}
+
+// ParseFrontMatter parses front matter in the given format
+func ParseFrontMatter(format string, data []byte) (*MetaData, error) {
+    switch format {
+    case FormatTOML:
+        return ParseMetaData(data)
+    case FormatYAML:
+        meta := &MetaData{}
+        if err := yaml.Unmarshal(data, meta); err != nil {
+            return meta, err
+        }
+        for key, value := range meta.Variables {
+            meta.Variables[key] = normalizeYAML(value)
+        }
+        return meta, nil
+    }
+    return nil, fmt.Errorf("unknown front matter format %q", format)
+}
+
+// Merge returns a copy of m with other layered on top. Scalars set in other
+// win, lists are appended and variables are merged key by key.
+func (m *MetaData) Merge(other *MetaData) *MetaData {
+    merged := &MetaData{
+        Template:     m.Template,
+        InlineStyle:  m.InlineStyle || other.InlineStyle,
+        InlineScript: m.InlineScript || other.InlineScript,
+        Head:         append(append([]template.HTML{}, m.Head...), other.Head...),
+        CSSImports:   append(append([]string{}, m.CSSImports...), other.CSSImports...),
+        JSImports:    append(append([]string{}, m.JSImports...), other.JSImports...),
+        Variables:    make(map[string]interface{}, len(m.Variables)+len(other.Variables)),
+    }
+
+    if other.Template != "" {
+        merged.Template = other.Template
+    }
+    for key, value := range m.Variables {
+        merged.Variables[key] = value
+    }
+    for key, value := range other.Variables {
+        merged.Variables[key] = value
+    }
+
+    return merged
+}
+
+// normalizeYAML converts the map[interface{}]interface{} values produced by
+// yaml.v2 so variables encode to JSON like their TOML counterparts
+func normalizeYAML(value interface{}) interface{} {
+    switch v := value.(type) {
+    case map[interface{}]interface{}:
+        m := make(map[string]interface{}, len(v))
+        for key, item := range v {
+            m[fmt.Sprint(key)] = normalizeYAML(item)
+        }
+        return m
+    case []interface{}:
+        for i, item := range v {
+            v[i] = normalizeYAML(item)
+        }
+    }
+    return value
+}
@@ -0,0 +1,38 @@
+// Package pages describes what the build writes for a page and the handlers
+// serve, so neither has to import the other
+package pages
+
+import (
+	"gogogo/modules/metaparser"
+	"gogogo/modules/router"
+)
+
+// Outputs the build writes for a page, next to its content.html
+const (
+	PageFile = "page.html" // Pre-rendered HTML
+	SPAFile  = "spa.json"  // Pre-built SPA payload
+)
+
+// ErrorsDir holds the error pages inside the content directory, one page
+// directory per status code such as _errors/404. It is not served directly.
+const ErrorsDir = "_errors"
+
+// SPAResponse is the payload of the SPA endpoint, also written by the build
+type SPAResponse struct {
+	Content   string               `json:"Content"`
+	Style     string               `json:"Style,omitempty"`
+	Script    string               `json:"Script,omitempty"`
+	StyleURL  string               `json:"StyleUrl,omitempty"`
+	ScriptURL string               `json:"ScriptUrl,omitempty"`
+	Meta      *metaparser.MetaData `json:"Meta,omitempty"`
+	Params    router.Params        `json:"Params,omitempty"`
+	Error     *SPAError            `json:"Error,omitempty"`
+	IsSPAMode bool
+}
+
+// SPAError tells the SPA client a navigation failed, the payload carries the
+// error page content when there is one
+type SPAError struct {
+	Status  int    `json:"Status"`
+	Message string `json:"Message"`
+}
@@ -0,0 +1,108 @@
+package reqinfo
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+// Info collects what serving a request involved, for the access log. Its
+// methods may be called concurrently and on a nil Info, which records nothing.
+type Info struct {
+	cacheHits   atomic.Int32
+	cacheMisses atomic.Int32
+	coalesced   atomic.Bool
+
+	mu       sync.Mutex
+	distPath string
+	route    string
+}
+
+type contextKey struct{}
+
+// NewContext returns ctx carrying a new Info to record into
+func NewContext(ctx context.Context) (context.Context, *Info) {
+	info := &Info{}
+	return context.WithValue(ctx, contextKey{}, info), info
+}
+
+// FromContext returns the Info of the request, nil when none is recorded
+func FromContext(ctx context.Context) *Info {
+	info, _ := ctx.Value(contextKey{}).(*Info)
+	return info
+}
+
+func (i *Info) CacheLookup(hit bool) {
+	if i == nil {
+		return
+	}
+	if hit {
+		i.cacheHits.Add(1)
+	} else {
+		i.cacheMisses.Add(1)
+	}
+}
+
+// Coalesced marks that the request shared a file read with another one
+func (i *Info) Coalesced() {
+	if i != nil {
+		i.coalesced.Store(true)
+	}
+}
+
+// ServedFrom records the dist file the response came from
+func (i *Info) ServedFrom(distPath string) {
+	if i == nil {
+		return
+	}
+	i.mu.Lock()
+	i.distPath = distPath
+	i.mu.Unlock()
+}
+
+// Cache sums up the cache lookups: "hit" when all hit, "miss" when any
+// missed and "-" without lookups
+func (i *Info) Cache() string {
+	switch {
+	case i == nil:
+		return "-"
+	case i.cacheMisses.Load() > 0:
+		return "miss"
+	case i.cacheHits.Load() > 0:
+		return "hit"
+	}
+	return "-"
+}
+
+func (i *Info) WasCoalesced() bool {
+	return i != nil && i.coalesced.Load()
+}
+
+func (i *Info) DistPath() string {
+	if i == nil {
+		return ""
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.distPath
+}
+
+// Routed records the route pattern that matched, e.g. "/blog/:slug"
+func (i *Info) Routed(route string) {
+	if i == nil {
+		return
+	}
+	i.mu.Lock()
+	i.route = route
+	i.mu.Unlock()
+}
+
+// Route returns the recorded route pattern, empty when none matched
+func (i *Info) Route() string {
+	if i == nil {
+		return ""
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.route
+}
@@ -0,0 +1,120 @@
+package router
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log/slog"
+	"time"
+)
+
+// Router binary layout, integers big endian:
+//
+//	magic      [4]byte  "GGRB"
+//	version    uint16
+//	idLen      uint16
+//	buildID    [idLen]byte
+//	builtAt    int64    unix nanoseconds
+//	payloadLen uint64
+//	checksum   uint32   CRC-32C of the payload
+//	payload    gob encoded *RadixNode
+const BinaryVersion = 1
+
+var binaryMagic = [4]byte{'G', 'G', 'R', 'B'}
+
+var (
+	ErrUnsupportedVersion = errors.New("unsupported router binary version")
+	ErrTruncated          = errors.New("router binary is truncated")
+	ErrChecksum           = errors.New("router binary checksum mismatch")
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// header is the fixed part after magic and version
+type binaryHeader struct {
+	buildID    string
+	builtAt    time.Time
+	payloadLen uint64
+	checksum   uint32
+}
+
+// WriteBinary serialises the tree below root in the framed router format
+func WriteBinary(w io.Writer, root *RadixNode, buildID string, builtAt time.Time) error {
+	if len(buildID) > 0xffff {
+		return fmt.Errorf("build ID too long: %d bytes", len(buildID))
+	}
+
+	var payload bytes.Buffer
+	if err := gob.NewEncoder(&payload).Encode(root); err != nil {
+		return fmt.Errorf("failed to encode router: %w", err)
+	}
+
+	head := make([]byte, 0, 4+2+2+len(buildID)+8+8+4)
+	head = append(head, binaryMagic[:]...)
+	head = binary.BigEndian.AppendUint16(head, BinaryVersion)
+	head = binary.BigEndian.AppendUint16(head, uint16(len(buildID)))
+	head = append(head, buildID...)
+	head = binary.BigEndian.AppendUint64(head, uint64(builtAt.UnixNano()))
+	head = binary.BigEndian.AppendUint64(head, uint64(payload.Len()))
+	head = binary.BigEndian.AppendUint32(head, crc32.Checksum(payload.Bytes(), crcTable))
+
+	if _, err := w.Write(head); err != nil {
+		return err
+	}
+	_, err := w.Write(payload.Bytes())
+	return err
+}
+
+// decodeBinary parses a router binary. Files without the magic number are
+// treated as the headerless gob format written by older builds.
+func decodeBinary(data []byte) (*RadixNode, binaryHeader, error) {
+	var h binaryHeader
+
+	if len(data) < len(binaryMagic) || !bytes.Equal(data[:len(binaryMagic)], binaryMagic[:]) {
+		root := &RadixNode{}
+		if err := gob.NewDecoder(bytes.NewReader(data)).Decode(root); err != nil {
+			return nil, h, fmt.Errorf("not a router binary and not a legacy gob router, rerun the build: %w", err)
+		}
+		slog.Warn("Loaded legacy router binary without header, rerun the build to upgrade it")
+		return root, h, nil
+	}
+
+	rest := data[len(binaryMagic):]
+	if len(rest) < 4 {
+		return nil, h, fmt.Errorf("%w: header cut short, rerun the build", ErrTruncated)
+	}
+	version := binary.BigEndian.Uint16(rest)
+	if version != BinaryVersion {
+		return nil, h, fmt.Errorf("%w: file is v%d, server reads v%d, rebuild with a matching cmd/build", ErrUnsupportedVersion, version, BinaryVersion)
+	}
+
+	idLen := int(binary.BigEndian.Uint16(rest[2:]))
+	rest = rest[4:]
+	if len(rest) < idLen+8+8+4 {
+		return nil, h, fmt.Errorf("%w: header cut short, rerun the build", ErrTruncated)
+	}
+	h.buildID = string(rest[:idLen])
+	rest = rest[idLen:]
+	h.builtAt = time.Unix(0, int64(binary.BigEndian.Uint64(rest)))
+	h.payloadLen = binary.BigEndian.Uint64(rest[8:])
+	h.checksum = binary.BigEndian.Uint32(rest[16:])
+	rest = rest[20:]
+
+	if uint64(len(rest)) != h.payloadLen {
+		return nil, h, fmt.Errorf("%w: expected %d payload bytes, found %d, the build may still be writing it or was interrupted", ErrTruncated, h.payloadLen, len(rest))
+	}
+	if sum := crc32.Checksum(rest, crcTable); sum != h.checksum {
+		return nil, h, fmt.Errorf("%w: expected %08x, got %08x, the file is corrupt, rerun the build", ErrChecksum, h.checksum, sum)
+	}
+
+	root := &RadixNode{}
+	if err := gob.NewDecoder(bytes.NewReader(rest)).Decode(root); err != nil {
+		return nil, h, fmt.Errorf("failed to decode router tree (build %s): %w", h.buildID, err)
+	}
+
+	return root, h, nil
+}
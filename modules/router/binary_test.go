@@ -0,0 +1,105 @@
+package router
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"testing"
+	"time"
+)
+
+func encodeTestBinary(t *testing.T, buildID string, builtAt time.Time) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := WriteBinary(&buf, testRouter("content/blog/:slug/content.html").root, buildID, builtAt); err != nil {
+		t.Fatalf("WriteBinary: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestBinaryRoundTrip(t *testing.T) {
+	builtAt := time.Date(2024, 5, 1, 12, 0, 0, 42, time.UTC)
+	data := encodeTestBinary(t, "abc123", builtAt)
+
+	if !bytes.HasPrefix(data, []byte("GGRB")) {
+		t.Fatalf("binary starts with %q, want the GGRB magic", data[:4])
+	}
+
+	root, h, err := decodeBinary(data)
+	if err != nil {
+		t.Fatalf("decodeBinary: %v", err)
+	}
+	if h.buildID != "abc123" || !h.builtAt.Equal(builtAt) {
+		t.Errorf("header = %q, %v, want %q, %v", h.buildID, h.builtAt, "abc123", builtAt)
+	}
+
+	r := &Router{root: root}
+	if distPath, ok := r.Route("content/blog/hello/content.html"); !ok || distPath != "dist/content/blog/:slug/content.html" {
+		t.Errorf("decoded Route = %q, %v", distPath, ok)
+	}
+}
+
+func TestDecodeBinaryErrors(t *testing.T) {
+	valid := encodeTestBinary(t, "abc123", time.Unix(0, 0))
+
+	// Offsets into the header of a build ID of 6 bytes
+	const (
+		versionAt  = 4
+		payloadAt  = 4 + 2 + 2 + 6 + 8 + 8 + 4
+		checksumAt = payloadAt - 4
+	)
+
+	tests := []struct {
+		name   string
+		mangle func([]byte) []byte
+		err    error
+	}{
+		{"newer version", func(b []byte) []byte {
+			binary.BigEndian.PutUint16(b[versionAt:], BinaryVersion+1)
+			return b
+		}, ErrUnsupportedVersion},
+		{"cut in header", func(b []byte) []byte { return b[:10] }, ErrTruncated},
+		{"cut after magic", func(b []byte) []byte { return b[:5] }, ErrTruncated},
+		{"cut in payload", func(b []byte) []byte { return b[:len(b)-1] }, ErrTruncated},
+		{"trailing bytes", func(b []byte) []byte { return append(b, 0) }, ErrTruncated},
+		{"flipped payload bit", func(b []byte) []byte {
+			b[payloadAt] ^= 1
+			return b
+		}, ErrChecksum},
+		{"wrong checksum", func(b []byte) []byte {
+			b[checksumAt] ^= 0xff
+			return b
+		}, ErrChecksum},
+	}
+
+	for _, tt := range tests {
+		data := tt.mangle(bytes.Clone(valid))
+		if _, _, err := decodeBinary(data); !errors.Is(err, tt.err) {
+			t.Errorf("%s: err = %v, want %v", tt.name, err, tt.err)
+		}
+	}
+}
+
+func TestDecodeLegacyBinary(t *testing.T) {
+	// Builds before the framed format wrote the gob encoded tree alone
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(testRouter("content/about/content.html").root); err != nil {
+		t.Fatal(err)
+	}
+
+	root, h, err := decodeBinary(buf.Bytes())
+	if err != nil {
+		t.Fatalf("decodeBinary: %v", err)
+	}
+	if h.buildID != "" || !h.builtAt.IsZero() {
+		t.Errorf("legacy header = %q, %v, want zero values", h.buildID, h.builtAt)
+	}
+	if _, ok := (&Router{root: root}).Route("content/about/content.html"); !ok {
+		t.Error("legacy route not found")
+	}
+
+	if _, _, err := decodeBinary([]byte("not a router")); err == nil {
+		t.Error("garbage decoded without error")
+	}
+}
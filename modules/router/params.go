@@ -0,0 +1,85 @@
+package router
+
+import (
+	"context"
+	"strings"
+)
+
+// Params holds the values matched by dynamic segments, keyed by name
+type Params map[string]string
+
+type paramsKey struct{}
+
+// WithParams returns a copy of ctx carrying params
+func WithParams(ctx context.Context, params Params) context.Context {
+	return context.WithValue(ctx, paramsKey{}, params)
+}
+
+// ParamsFromContext returns the params stored by WithParams, or nil
+func ParamsFromContext(ctx context.Context) Params {
+	params, _ := ctx.Value(paramsKey{}).(Params)
+	return params
+}
+
+// DynamicSegment converts a content directory name to its route segment,
+// "[slug]" becomes ":slug" and "[...rest]" becomes "*rest". Other names are
+// returned unchanged with ok set to false.
+func DynamicSegment(name string) (string, bool) {
+	if len(name) < 3 || name[0] != '[' || name[len(name)-1] != ']' {
+		return name, false
+	}
+
+	inner := name[1 : len(name)-1]
+	if rest, ok := strings.CutPrefix(inner, "..."); ok && rest != "" {
+		return "*" + rest, true
+	}
+	if strings.HasPrefix(inner, ".") {
+		return name, false
+	}
+
+	return ":" + inner, true
+}
+
+func isParam(segment string) bool {
+	return len(segment) > 1 && segment[0] == ':'
+}
+
+func isCatchAll(segment string) bool {
+	return len(segment) > 1 && segment[0] == '*'
+}
+
+// matchState records the pattern and values of the branch being matched
+type matchState struct {
+	pattern []string
+	values  []string
+}
+
+func (m *matchState) push(segment, value string) {
+	if m == nil {
+		return
+	}
+	m.pattern = append(m.pattern, segment)
+	m.values = append(m.values, value)
+}
+
+func (m *matchState) pop() {
+	if m == nil {
+		return
+	}
+	m.pattern = m.pattern[:len(m.pattern)-1]
+	m.values = m.values[:len(m.values)-1]
+}
+
+func (m *matchState) params() Params {
+	var params Params
+	for i, segment := range m.pattern {
+		// Literal lookups of a pattern path leave the value empty
+		if m.values[i] != "" && (isParam(segment) || isCatchAll(segment)) {
+			if params == nil {
+				params = make(Params, 2)
+			}
+			params[segment[1:]] = m.values[i]
+		}
+	}
+	return params
+}
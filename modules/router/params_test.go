@@ -0,0 +1,218 @@
+package router
+
+import (
+	"bytes"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDynamicSegment(t *testing.T) {
+	tests := []struct {
+		name    string
+		segment string
+		ok      bool
+	}{
+		{"[slug]", ":slug", true},
+		{"[...rest]", "*rest", true},
+		{"blog", "blog", false},
+		{"[]", "[]", false},
+		{"[...]", "[...]", false},
+		{"[.hidden]", "[.hidden]", false},
+		{"[slug", "[slug", false},
+	}
+
+	for _, tt := range tests {
+		segment, ok := DynamicSegment(tt.name)
+		if segment != tt.segment || ok != tt.ok {
+			t.Errorf("DynamicSegment(%q) = %q, %v, want %q, %v", tt.name, segment, ok, tt.segment, tt.ok)
+		}
+	}
+}
+
+func testRouter(files ...string) *Router {
+	r := New()
+	for _, file := range files {
+		r.root.Insert(strings.Split(file, "/"), &FileInfo{DistPath: "dist/" + file})
+	}
+	return r
+}
+
+// testRouters serves files from both a route tree and a route table, which
+// must answer alike
+func testRouters(t *testing.T, files ...string) map[string]*Router {
+	t.Helper()
+	routes := make(map[string]FileInfo, len(files))
+	for _, file := range files {
+		routes[file] = FileInfo{DistPath: "dist/" + file}
+	}
+
+	var buf bytes.Buffer
+	if err := WriteTable(&buf, routes, "test", time.Unix(0, 0)); err != nil {
+		t.Fatalf("WriteTable: %v", err)
+	}
+	table, err := openTable(buf.Bytes())
+	if err != nil {
+		t.Fatalf("openTable: %v", err)
+	}
+
+	return map[string]*Router{
+		"tree":  testRouter(files...),
+		"table": {table: table},
+	}
+}
+
+func TestMatch(t *testing.T) {
+	routers := testRouters(t,
+		"content/content.html",
+		"content/blog/about/content.html",
+		"content/blog/:slug/content.html",
+		"content/blog/:slug/comments/content.html",
+		"content/docs/*rest/content.html",
+		"content/users/:id/content.html",
+		"content/users/*all/content.html",
+	)
+
+	tests := []struct {
+		path    string
+		pattern string
+		params  Params
+		ok      bool
+	}{
+		{"content", "content", nil, true},
+
+		{"content/blog/about", "content/blog/about", nil, true},
+		{"content/blog/hello", "content/blog/:slug", Params{"slug": "hello"}, true},
+
+		// The literal directory has no comments, so :slug takes over
+		{"content/blog/about/comments", "content/blog/:slug/comments", Params{"slug": "about"}, true},
+
+		{"content/docs/a", "content/docs/*rest", Params{"rest": "a"}, true},
+		{"content/docs/a/b/c", "content/docs/*rest", Params{"rest": "a/b/c"}, true},
+
+		// :param wins over *catchall for a single segment only
+		{"content/users/42", "content/users/:id", Params{"id": "42"}, true},
+		{"content/users/42/posts", "content/users/*all", Params{"all": "42/posts"}, true},
+
+		{"/content//blog/hello/", "content/blog/:slug", Params{"slug": "hello"}, true},
+		{"content/missing", "", nil, false},
+		{"other", "", nil, false},
+	}
+
+	for name, r := range routers {
+		for _, tt := range tests {
+			pattern, params, ok := r.Match(tt.path)
+			if pattern != tt.pattern || !reflect.DeepEqual(params, tt.params) || ok != tt.ok {
+				t.Errorf("%s: Match(%q) = %q, %v, %v, want %q, %v, %v", name, tt.path, pattern, params, ok, tt.pattern, tt.params, tt.ok)
+			}
+		}
+	}
+}
+
+func TestMatchSiblings(t *testing.T) {
+	// Siblings continuing the name with a byte below '/' sort between
+	// content/blog and its files, no dynamic route covers for them
+	routers := testRouters(t,
+		"content/blog-archive/content.html",
+		"content/blog.old/content.html",
+		"content/blog/content.html",
+		"content/blog/2024/content.html",
+	)
+
+	tests := []struct {
+		path    string
+		pattern string
+		ok      bool
+	}{
+		{"content/blog", "content/blog", true},
+		{"content/blog-archive", "content/blog-archive", true},
+		{"content/blog.old", "content/blog.old", true},
+		{"content/blog/2024", "content/blog/2024", true},
+		{"content/blo", "", false},
+		{"content/blog-", "", false},
+	}
+
+	for name, r := range routers {
+		for _, tt := range tests {
+			pattern, _, ok := r.Match(tt.path)
+			if pattern != tt.pattern || ok != tt.ok {
+				t.Errorf("%s: Match(%q) = %q, %v, want %q, %v", name, tt.path, pattern, ok, tt.pattern, tt.ok)
+			}
+		}
+	}
+}
+
+func TestRoute(t *testing.T) {
+	routers := testRouters(t,
+		"content/blog-archive/content.html",
+		"content/blog/about/content.html",
+		"content/blog/:slug/content.html",
+		"content/blog/:slug/meta.toml",
+		"content/docs/*rest/content.html",
+		"content/guides/*rest/edit/content.html",
+	)
+
+	tests := []struct {
+		path     string
+		distPath string
+		ok       bool
+	}{
+		{"content/blog/about/content.html", "dist/content/blog/about/content.html", true},
+		{"content/blog-archive/content.html", "dist/content/blog-archive/content.html", true},
+
+		// Only :slug has a meta.toml, the literal branch is left for it
+		{"content/blog/about/meta.toml", "dist/content/blog/:slug/meta.toml", true},
+		{"content/blog/hello/content.html", "dist/content/blog/:slug/content.html", true},
+		{"content/docs/a/b/content.html", "dist/content/docs/*rest/content.html", true},
+
+		// The catch-all gives back segments until its children match
+		{"content/guides/a/b/edit/content.html", "dist/content/guides/*rest/edit/content.html", true},
+		{"content/guides/a/b/content.html", "", false},
+
+		// Directories are no files
+		{"content/blog/hello", "", false},
+		{"content/docs/content.html", "", false},
+	}
+
+	for name, r := range routers {
+		for _, tt := range tests {
+			distPath, ok := r.Route(tt.path)
+			if distPath != tt.distPath || ok != tt.ok {
+				t.Errorf("%s: Route(%q) = %q, %v, want %q, %v", name, tt.path, distPath, ok, tt.distPath, tt.ok)
+			}
+		}
+	}
+}
+
+func TestList(t *testing.T) {
+	routers := testRouters(t,
+		"content/blog/content.html",
+		"content/blog/meta.toml",
+		"content/blog/about/content.html",
+		"content/blog-archive/content.html",
+		"content/blog/:slug/content.html",
+	)
+
+	tests := []struct {
+		path  string
+		names []string
+		ok    bool
+	}{
+		{"content/blog", []string{"content.html", "meta.toml"}, true},
+		{"content/blog-archive", []string{"content.html"}, true},
+		{"content/blog/hello", []string{"content.html"}, true},
+		{"content/missing", nil, false},
+	}
+
+	for name, r := range routers {
+		for _, tt := range tests {
+			names, ok := r.List(tt.path)
+			sort.Strings(names)
+			if !reflect.DeepEqual(names, tt.names) || ok != tt.ok {
+				t.Errorf("%s: List(%q) = %v, %v, want %v, %v", name, tt.path, names, ok, tt.names, tt.ok)
+			}
+		}
+	}
+}
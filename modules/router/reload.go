@@ -0,0 +1,194 @@
+package router
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+const reloadDebounce = 250 * time.Millisecond
+
+// Reload loads the router binary at binPath and swaps it in once every file
+// it routes to exists, so requests never see a half deployed build. It
+// returns the dist paths that only the previous tree referenced, callers
+// purge them from caches.
+func (r *Router) Reload(binPath string) (stale []string, err error) {
+	r.reloadMu.Lock()
+	defer r.reloadMu.Unlock()
+
+	defer func() {
+		if err != nil {
+			r.reloadsFailed.Add(1)
+		} else {
+			r.reloads.Add(1)
+		}
+	}()
+
+	load := r.load
+	if load == nil {
+		load = LoadFromBinary
+	}
+	loaded, err := load(binPath)
+	if err != nil {
+		return nil, err
+	}
+
+	next := loaded.distPaths()
+	for distPath := range next {
+		if _, err := os.Stat(distPath); err != nil {
+			loaded.closeTable()
+			return nil, fmt.Errorf("router binary references missing file: %w", err)
+		}
+	}
+
+	r.rwMutex.Lock()
+	prev := &Router{root: r.root, table: r.table}
+	r.root = loaded.root
+	r.table = loaded.table
+	r.buildID = loaded.buildID
+	r.builtAt = loaded.builtAt
+	r.rwMutex.Unlock()
+
+	for distPath := range prev.distPaths() {
+		if _, ok := next[distPath]; !ok {
+			stale = append(stale, distPath)
+		}
+	}
+
+	// No reader can still be inside the old table once the write lock
+	// was held, dist paths handed out live on the heap
+	prev.closeTable()
+
+	return stale, nil
+}
+
+// ReloadCounts reports how many reloads swapped in a new build and how many
+// failed, keeping the routes served before
+func (r *Router) ReloadCounts() (succeeded, failed uint64) {
+	return r.reloads.Load(), r.reloadsFailed.Load()
+}
+
+// distPaths collects the dist path of every routed file
+func (r *Router) distPaths() map[string]struct{} {
+	if r.table != nil {
+		return r.table.distPaths()
+	}
+	return distPaths(r.root)
+}
+
+func (r *Router) closeTable() {
+	if r.table != nil {
+		r.table.Close()
+	}
+}
+
+// Watch reloads the router whenever the build rewrites binPath. The
+// directory is watched rather than the file as the build replaces it with a
+// rename.
+func (r *Router) Watch(binPath string) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("failed to create router watcher: %w", err)
+	}
+
+	if err := watcher.Add(filepath.Dir(binPath)); err != nil {
+		watcher.Close()
+		return fmt.Errorf("failed to watch router binary: %w", err)
+	}
+
+	r.reloadMu.Lock()
+	r.watcher = watcher
+	r.reloadMu.Unlock()
+
+	go r.watch(watcher, binPath)
+
+	return nil
+}
+
+// Close stops watching the router binary and releases a mapped route table
+func (r *Router) Close() error {
+	r.reloadMu.Lock()
+	defer r.reloadMu.Unlock()
+
+	r.rwMutex.Lock()
+	r.closeTable()
+	r.table = nil
+	// Routes of a closed table are gone, lookups find nothing
+	if r.root == nil {
+		r.root = &RadixNode{}
+	}
+	r.rwMutex.Unlock()
+
+	if r.watcher == nil {
+		return nil
+	}
+	err := r.watcher.Close()
+	r.watcher = nil
+	return err
+}
+
+func (r *Router) watch(watcher *fsnotify.Watcher, binPath string) {
+	var timer *time.Timer
+	name := filepath.Base(binPath)
+
+	reload := func() {
+		stale, err := r.Reload(binPath)
+		if err != nil {
+			slog.Error("Router reload failed, keeping current routes", "error", err)
+			return
+		}
+
+		slog.Info("Router reloaded", "build", r.BuildID(), "stale_files", len(stale))
+		if r.OnReload != nil {
+			r.OnReload(stale)
+		}
+	}
+
+	for {
+		select {
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			if filepath.Base(event.Name) != name || event.Op&(fsnotify.Write|fsnotify.Create) == 0 {
+				continue
+			}
+
+			if timer == nil {
+				timer = time.AfterFunc(reloadDebounce, reload)
+			} else {
+				timer.Reset(reloadDebounce)
+			}
+
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			slog.Error("Router watcher error", "error", err)
+		}
+	}
+}
+
+// distPaths collects the dist path of every routed file below node
+func distPaths(node *RadixNode) map[string]struct{} {
+	paths := make(map[string]struct{})
+
+	var walk func(*RadixNode)
+	walk = func(n *RadixNode) {
+		if n.FileInfo != nil && n.FileInfo.DistPath != "" {
+			paths[n.FileInfo.DistPath] = struct{}{}
+		}
+		for _, child := range n.Children {
+			walk(child)
+		}
+	}
+	if node != nil {
+		walk(node)
+	}
+
+	return paths
+}
@@ -0,0 +1,43 @@
+package router
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCloseTable(t *testing.T) {
+	tablePath := filepath.Join(t.TempDir(), "routes.tbl")
+	file, err := os.Create(tablePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	routes := map[string]FileInfo{"content/about/content.html": {DistPath: "dist/content/about/content.html"}}
+	if err := WriteTable(file, routes, "abc123", time.Unix(0, 0)); err != nil {
+		t.Fatalf("WriteTable: %v", err)
+	}
+	file.Close()
+
+	r, err := LoadTable(tablePath)
+	if err != nil {
+		t.Fatalf("LoadTable: %v", err)
+	}
+	if _, ok := r.Route("content/about/content.html"); !ok {
+		t.Fatal("route not found before Close")
+	}
+	if err := r.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	// A closed router finds nothing rather than dereferencing a nil tree
+	if _, ok := r.Route("content/about/content.html"); ok {
+		t.Error("Route found a route after Close")
+	}
+	if _, ok := r.Info("content/about/content.html"); ok {
+		t.Error("Info found a route after Close")
+	}
+	if _, _, ok := r.Match("content/about"); ok {
+		t.Error("Match found a route after Close")
+	}
+}
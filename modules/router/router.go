@@ -1,15 +1,29 @@
 package router
 
 import (
-	"encoding/gob"
+	"fmt"
 	"os"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/fsnotify/fsnotify"
 )
 
 type Router struct {
-	root    *RadixNode
-	rwMutex sync.RWMutex
+	root     *RadixNode
+	table    *Table // set instead of root when serving a compiled route table
+	load     func(string) (*Router, error)
+	buildID  string
+	builtAt  time.Time
+	rwMutex  sync.RWMutex
+	reloadMu sync.Mutex // serialises Reload calls
+	watcher  *fsnotify.Watcher
+	OnReload func(stale []string) // Called after a watched binary was swapped in
+
+	reloads       atomic.Uint64
+	reloadsFailed atomic.Uint64
 }
 
 type FileInfo struct {
@@ -17,6 +31,13 @@ type FileInfo struct {
 	DistPath    string    `json:"DistPath"`
 	DependsOn   []string  `json:"DependsOn"`
 	AliasedPath string    `json:"AliasedPath"`
+	Size        int64     `json:"Size"`
+	ETag        string    `json:"ETag"`
+	ContentType string    `json:"ContentType"`
+
+	// Precompressed variants next to DistPath by content coding, with their
+	// sizes. Each is routed at the key plus compression.Extension.
+	Encodings map[string]int64 `json:"Encodings,omitempty"`
 }
 
 type RadixNode struct {
@@ -33,27 +54,48 @@ func New() *Router {
 	}
 }
 
+// LoadFromBinary reads a router binary written by the build
 func LoadFromBinary(binPath string) (*Router, error) {
-	f, err := os.Open(binPath)
+	data, err := os.ReadFile(binPath)
 	if err != nil {
 		return nil, err
 	}
-	defer f.Close()
 
-	root := &RadixNode{}
-	dec := gob.NewDecoder(f)
-	if err := dec.Decode(root); err != nil {
-		return nil, err
+	root, h, err := decodeBinary(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", binPath, err)
 	}
 
 	return &Router{
-		root: root,
+		root:    root,
+		buildID: h.buildID,
+		builtAt: h.builtAt,
+		load:    LoadFromBinary,
 	}, nil
 }
 
+// BuildID identifies the build the routes come from, empty for legacy binaries
+func (r *Router) BuildID() string {
+	r.rwMutex.RLock()
+	defer r.rwMutex.RUnlock()
+	return r.buildID
+}
+
+// BuiltAt reports when the routes were built, zero for legacy binaries
+func (r *Router) BuiltAt() time.Time {
+	r.rwMutex.RLock()
+	defer r.rwMutex.RUnlock()
+	return r.builtAt
+}
+
 // Route finds the dist path for a given request path
 func (r *Router) Route(path string) (string, bool) {
 	r.rwMutex.RLock()
+	if r.table != nil {
+		distPath, ok := r.table.Route(path)
+		r.rwMutex.RUnlock()
+		return distPath, ok
+	}
 	fileInfo := r.findRoute(path)
 	r.rwMutex.RUnlock()
 
@@ -64,35 +106,138 @@ func (r *Router) Route(path string) (string, bool) {
 	return fileInfo.DistPath, true
 }
 
+// Info returns everything the build recorded for a given request path
+func (r *Router) Info(path string) (*FileInfo, bool) {
+	r.rwMutex.RLock()
+	defer r.rwMutex.RUnlock()
+
+	if r.table != nil {
+		return r.table.Info(path)
+	}
+
+	fileInfo := r.findRoute(path)
+	return fileInfo, fileInfo != nil
+}
+
+// Match resolves path to the route pattern it was built from, e.g.
+// "content/blog/hello" to "content/blog/:slug", along with the values
+// captured by dynamic segments
+func (r *Router) Match(path string) (string, Params, bool) {
+	m := &matchState{}
+
+	r.rwMutex.RLock()
+	if r.table != nil {
+		defer r.rwMutex.RUnlock()
+		return r.table.match(path)
+	}
+	node := match(r.root, path, false, m)
+	r.rwMutex.RUnlock()
+
+	if node == nil {
+		return "", nil, false
+	}
+
+	return strings.Join(m.pattern, "/"), m.params(), true
+}
+
+// List returns the names of routed files directly below path
+func (r *Router) List(path string) ([]string, bool) {
+	r.rwMutex.RLock()
+	defer r.rwMutex.RUnlock()
+
+	if r.table != nil {
+		return r.table.list(path)
+	}
+
+	node := r.findNode(path)
+	if node == nil {
+		return nil, false
+	}
+
+	names := make([]string, 0, len(node.Children))
+	for _, child := range node.Children {
+		if child.FileInfo != nil {
+			names = append(names, child.Path)
+		}
+	}
+
+	return names, true
+}
+
 func (r *Router) findRoute(path string) *FileInfo {
-	node := r.root
-	if len(path) <= 1 {
-		return node.FileInfo
-	}
-
-	var start, end int
-	for end <= len(path) {
-		if end == len(path) || path[end] == '/' {
-			if end > start {
-				segment := path[start:end]
-				found := false
-				for _, child := range node.Children {
-					if child.Path == segment {
-						node = child
-						found = true
-						break
-					}
-				}
-				if !found {
-					return nil
-				}
+	node := match(r.root, path, true, nil)
+	if node == nil {
+		return nil
+	}
+
+	return node.FileInfo
+}
+
+func (r *Router) findNode(path string) *RadixNode {
+	return match(r.root, path, false, nil)
+}
+
+// match walks path segment by segment, preferring literal children over
+// :param children over *catchall children and backtracking when a branch
+// dead ends. With leaf set only nodes carrying a FileInfo match.
+func match(node *RadixNode, path string, leaf bool, m *matchState) *RadixNode {
+	for len(path) > 0 && path[0] == '/' {
+		path = path[1:]
+	}
+	if path == "" {
+		if leaf && node.FileInfo == nil {
+			return nil
+		}
+		return node
+	}
+
+	end := strings.IndexByte(path, '/')
+	if end < 0 {
+		end = len(path)
+	}
+	segment, rest := path[:end], path[end:]
+
+	for _, child := range node.Children {
+		if child.Path == segment {
+			m.push(child.Path, "")
+			if found := match(child, rest, leaf, m); found != nil {
+				return found
 			}
-			start = end + 1
+			m.pop()
+			break
 		}
-		end++
 	}
 
-	return node.FileInfo
+	for _, child := range node.Children {
+		if isParam(child.Path) {
+			m.push(child.Path, segment)
+			if found := match(child, rest, leaf, m); found != nil {
+				return found
+			}
+			m.pop()
+		}
+	}
+
+	for _, child := range node.Children {
+		if !isCatchAll(child.Path) {
+			continue
+		}
+		// Take as many segments as possible, giving them back one at a
+		// time so the remainder can still match the node's children
+		for cut := len(path); cut > 0; cut = strings.LastIndexByte(path[:cut], '/') {
+			value := strings.TrimRight(path[:cut], "/")
+			if value == "" {
+				continue
+			}
+			m.push(child.Path, value)
+			if found := match(child, path[cut:], leaf, m); found != nil {
+				return found
+			}
+			m.pop()
+		}
+	}
+
+	return nil
 }
 
 func (n *RadixNode) Insert(segments []string, fileInfo *FileInfo) {
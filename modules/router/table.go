@@ -0,0 +1,411 @@
+package router
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unsafe"
+)
+
+// Route table layout, integers big endian:
+//
+//	magic        [4]byte  "GGRT"
+//	version      uint16
+//	idLen        uint16
+//	buildID      [idLen]byte
+//	builtAt      int64    unix nanoseconds
+//	staticCount  uint32   entries looked up by binary search, sorted by key
+//	dynamicCount uint32   entries with :param or *catchall segments
+//	keysLen      uint32
+//	valuesLen    uint32
+//	checksum     uint32   CRC-32C of everything after the header
+//	index        [staticCount+dynamicCount]entry
+//	keys         [keysLen]byte
+//	values       [valuesLen]byte
+//
+// An entry is six uint32s: key offset and length, dist path offset and
+// length, FileInfo JSON offset and length. Offsets are relative to their
+// blob, keys are normalised paths without leading or trailing slashes.
+const TableVersion = 1
+
+const tableEntrySize = 6 * 4
+
+var tableMagic = [4]byte{'G', 'G', 'R', 'T'}
+
+// Table is a compiled, read-only route table. Keys and the index stay in the
+// mapped file, values are copied to the heap once so the dist paths handed
+// out remain valid after the table is closed.
+type Table struct {
+	data    []byte
+	index   []byte
+	keys    []byte
+	values  []byte
+	static  int
+	infos   []atomic.Pointer[FileInfo] // decoded on first use
+	dynamic *RadixNode
+	buildID string
+	builtAt time.Time
+	unmap   func() error
+}
+
+// WriteTable serialises routes, keyed by path as in the router tree, in the
+// flat route table format
+func WriteTable(w io.Writer, routes map[string]FileInfo, buildID string, builtAt time.Time) error {
+	if len(buildID) > 0xffff {
+		return fmt.Errorf("build ID too long: %d bytes", len(buildID))
+	}
+
+	var static, dynamic []string
+	for key := range routes {
+		if isDynamicKey(key) {
+			dynamic = append(dynamic, key)
+		} else {
+			static = append(static, key)
+		}
+	}
+	sort.Slice(static, func(i, j int) bool {
+		return normalizeKey(static[i]) < normalizeKey(static[j])
+	})
+	sort.Strings(dynamic)
+
+	count := len(static) + len(dynamic)
+	index := make([]byte, 0, count*tableEntrySize)
+	var keys, values bytes.Buffer
+
+	for _, key := range append(static, dynamic...) {
+		info := routes[key]
+		encoded, err := json.Marshal(info)
+		if err != nil {
+			return fmt.Errorf("failed to encode route %q: %w", key, err)
+		}
+
+		normalized := normalizeKey(key)
+		index = binary.BigEndian.AppendUint32(index, uint32(keys.Len()))
+		index = binary.BigEndian.AppendUint32(index, uint32(len(normalized)))
+		keys.WriteString(normalized)
+
+		index = binary.BigEndian.AppendUint32(index, uint32(values.Len()))
+		index = binary.BigEndian.AppendUint32(index, uint32(len(info.DistPath)))
+		values.WriteString(info.DistPath)
+
+		index = binary.BigEndian.AppendUint32(index, uint32(values.Len()))
+		index = binary.BigEndian.AppendUint32(index, uint32(len(encoded)))
+		values.Write(encoded)
+	}
+
+	sum := crc32.Update(0, crcTable, index)
+	sum = crc32.Update(sum, crcTable, keys.Bytes())
+	sum = crc32.Update(sum, crcTable, values.Bytes())
+
+	head := make([]byte, 0, 4+2+2+len(buildID)+8+5*4)
+	head = append(head, tableMagic[:]...)
+	head = binary.BigEndian.AppendUint16(head, TableVersion)
+	head = binary.BigEndian.AppendUint16(head, uint16(len(buildID)))
+	head = append(head, buildID...)
+	head = binary.BigEndian.AppendUint64(head, uint64(builtAt.UnixNano()))
+	head = binary.BigEndian.AppendUint32(head, uint32(len(static)))
+	head = binary.BigEndian.AppendUint32(head, uint32(len(dynamic)))
+	head = binary.BigEndian.AppendUint32(head, uint32(keys.Len()))
+	head = binary.BigEndian.AppendUint32(head, uint32(values.Len()))
+	head = binary.BigEndian.AppendUint32(head, sum)
+
+	for _, part := range [][]byte{head, index, keys.Bytes(), values.Bytes()} {
+		if _, err := w.Write(part); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LoadTable maps a route table written by the build and returns a router
+// serving from it
+func LoadTable(tablePath string) (*Router, error) {
+	data, unmap, err := mapFile(tablePath)
+	if err != nil {
+		return nil, err
+	}
+
+	t, err := openTable(data)
+	if err != nil {
+		unmap()
+		return nil, fmt.Errorf("%s: %w", tablePath, err)
+	}
+	t.unmap = unmap
+
+	return &Router{
+		table:   t,
+		buildID: t.buildID,
+		builtAt: t.builtAt,
+		load:    LoadTable,
+	}, nil
+}
+
+func openTable(data []byte) (*Table, error) {
+	if len(data) < len(tableMagic) || !bytes.Equal(data[:len(tableMagic)], tableMagic[:]) {
+		return nil, fmt.Errorf("not a route table, rerun the build or set router format to \"tree\"")
+	}
+
+	rest := data[len(tableMagic):]
+	if len(rest) < 4 {
+		return nil, fmt.Errorf("%w: header cut short, rerun the build", ErrTruncated)
+	}
+	version := binary.BigEndian.Uint16(rest)
+	if version != TableVersion {
+		return nil, fmt.Errorf("%w: route table is v%d, server reads v%d, rebuild with a matching cmd/build", ErrUnsupportedVersion, version, TableVersion)
+	}
+
+	idLen := int(binary.BigEndian.Uint16(rest[2:]))
+	rest = rest[4:]
+	if len(rest) < idLen+8+5*4 {
+		return nil, fmt.Errorf("%w: header cut short, rerun the build", ErrTruncated)
+	}
+
+	t := &Table{data: data}
+	t.buildID = string(rest[:idLen])
+	rest = rest[idLen:]
+	t.builtAt = time.Unix(0, int64(binary.BigEndian.Uint64(rest)))
+	static := int(binary.BigEndian.Uint32(rest[8:]))
+	dynamic := int(binary.BigEndian.Uint32(rest[12:]))
+	keysLen := int(binary.BigEndian.Uint32(rest[16:]))
+	valuesLen := int(binary.BigEndian.Uint32(rest[20:]))
+	checksum := binary.BigEndian.Uint32(rest[24:])
+	rest = rest[28:]
+
+	indexLen := (static + dynamic) * tableEntrySize
+	if want := indexLen + keysLen + valuesLen; len(rest) != want {
+		return nil, fmt.Errorf("%w: expected %d bytes after the header, found %d, the build may still be writing it or was interrupted", ErrTruncated, want, len(rest))
+	}
+	if sum := crc32.Checksum(rest, crcTable); sum != checksum {
+		return nil, fmt.Errorf("%w: expected %08x, got %08x, the file is corrupt, rerun the build", ErrChecksum, checksum, sum)
+	}
+
+	t.index = rest[:indexLen]
+	t.keys = rest[indexLen : indexLen+keysLen]
+	// One allocation for every value, dist paths point into it
+	t.values = append([]byte(nil), rest[indexLen+keysLen:]...)
+	t.static = static
+	t.infos = make([]atomic.Pointer[FileInfo], static+dynamic)
+
+	if dynamic > 0 {
+		t.dynamic = &RadixNode{}
+		for i := static; i < static+dynamic; i++ {
+			info, err := t.info(i)
+			if err != nil {
+				return nil, err
+			}
+			t.dynamic.Insert(strings.Split(string(t.key(i)), "/"), info)
+		}
+	}
+
+	return t, nil
+}
+
+// Close releases the mapped file
+func (t *Table) Close() error {
+	if t.unmap == nil {
+		return nil
+	}
+	err := t.unmap()
+	t.unmap = nil
+	return err
+}
+
+// Len returns the number of routes in the table
+func (t *Table) Len() int {
+	return len(t.infos)
+}
+
+// Route returns the dist path for path without allocating
+func (t *Table) Route(path string) (string, bool) {
+	if i, ok := t.search(path); ok {
+		return t.distPath(i), true
+	}
+
+	if t.dynamic != nil {
+		if node := match(t.dynamic, path, true, nil); node != nil {
+			return node.FileInfo.DistPath, true
+		}
+	}
+	return "", false
+}
+
+// Info returns the FileInfo stored for path, decoding it on first use
+func (t *Table) Info(path string) (*FileInfo, bool) {
+	if i, ok := t.search(path); ok {
+		info, err := t.info(i)
+		return info, err == nil
+	}
+
+	if t.dynamic != nil {
+		if node := match(t.dynamic, path, true, nil); node != nil {
+			return node.FileInfo, true
+		}
+	}
+	return nil, false
+}
+
+// match resolves a directory, static directories are any prefix of a key
+func (t *Table) match(path string) (string, Params, bool) {
+	var buf [256]byte
+	dir := normalizePath(path, buf[:0])
+
+	if i := t.lowerBound(dir); i < t.static && bytes.Equal(t.key(i), dir) {
+		return string(dir), nil, true
+	}
+
+	// Siblings such as blog-archive sort between blog and blog/, so the
+	// search starts at the directory's own prefix
+	prefix := append(dir, '/')
+	if len(dir) == 0 {
+		prefix = prefix[:0]
+	}
+	if i := t.lowerBound(prefix); i < t.static && bytes.HasPrefix(t.key(i), prefix) {
+		return string(dir), nil, true
+	}
+
+	if t.dynamic != nil {
+		m := &matchState{}
+		if match(t.dynamic, path, false, m) != nil {
+			return strings.Join(m.pattern, "/"), m.params(), true
+		}
+	}
+	return "", nil, false
+}
+
+// list returns the names of files directly below path
+func (t *Table) list(path string) ([]string, bool) {
+	var buf [256]byte
+	dir := normalizePath(path, buf[:0])
+	prefix := append(dir, '/')
+	if len(dir) == 0 {
+		prefix = prefix[:0]
+	}
+
+	var names []string
+	found := false
+	for i := t.lowerBound(prefix); i < t.static; i++ {
+		key := t.key(i)
+		if !bytes.HasPrefix(key, prefix) {
+			break
+		}
+		found = true
+		if name := key[len(prefix):]; bytes.IndexByte(name, '/') < 0 {
+			names = append(names, string(name))
+		}
+	}
+
+	if t.dynamic != nil {
+		if node := match(t.dynamic, path, false, nil); node != nil {
+			found = true
+			for _, child := range node.Children {
+				if child.FileInfo != nil {
+					names = append(names, child.Path)
+				}
+			}
+		}
+	}
+
+	return names, found
+}
+
+// distPaths collects the dist path of every route
+func (t *Table) distPaths() map[string]struct{} {
+	paths := make(map[string]struct{}, len(t.infos))
+	for i := range t.infos {
+		if p := t.distPath(i); p != "" {
+			paths[p] = struct{}{}
+		}
+	}
+	return paths
+}
+
+func (t *Table) search(path string) (int, bool) {
+	var buf [256]byte
+	key := normalizePath(path, buf[:0])
+
+	i := t.lowerBound(key)
+	if i < t.static && bytes.Equal(t.key(i), key) {
+		return i, true
+	}
+	return 0, false
+}
+
+// lowerBound returns the first static entry whose key is not below key
+func (t *Table) lowerBound(key []byte) int {
+	lo, hi := 0, t.static
+	for lo < hi {
+		mid := int(uint(lo+hi) >> 1)
+		if bytes.Compare(t.key(mid), key) < 0 {
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
+	}
+	return lo
+}
+
+func (t *Table) entry(i, field int) int {
+	return int(binary.BigEndian.Uint32(t.index[i*tableEntrySize+field*4:]))
+}
+
+func (t *Table) key(i int) []byte {
+	off := t.entry(i, 0)
+	return t.keys[off : off+t.entry(i, 1)]
+}
+
+func (t *Table) distPath(i int) string {
+	n := t.entry(i, 3)
+	if n == 0 {
+		return ""
+	}
+	return unsafe.String(&t.values[t.entry(i, 2)], n)
+}
+
+func (t *Table) info(i int) (*FileInfo, error) {
+	if info := t.infos[i].Load(); info != nil {
+		return info, nil
+	}
+
+	off := t.entry(i, 4)
+	info := &FileInfo{}
+	if err := json.Unmarshal(t.values[off:off+t.entry(i, 5)], info); err != nil {
+		return nil, fmt.Errorf("failed to decode route %q: %w", t.key(i), err)
+	}
+	t.infos[i].Store(info)
+	return info, nil
+}
+
+// normalizePath appends path to buf without leading, trailing or repeated
+// slashes, matching how the tree skips empty segments
+func normalizePath(path string, buf []byte) []byte {
+	for i := 0; i < len(path); i++ {
+		if path[i] == '/' && (len(buf) == 0 || buf[len(buf)-1] == '/') {
+			continue
+		}
+		buf = append(buf, path[i])
+	}
+	if n := len(buf); n > 0 && buf[n-1] == '/' {
+		buf = buf[:n-1]
+	}
+	return buf
+}
+
+func normalizeKey(key string) string {
+	return string(normalizePath(key, nil))
+}
+
+func isDynamicKey(key string) bool {
+	for _, segment := range strings.Split(key, "/") {
+		if isParam(segment) || isCatchAll(segment) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,169 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"time"
+)
+
+// Admin serves operational endpoints, such as metrics, on a listener of its
+// own so they stay off the public address. It can listen on a TCP address,
+// a Unix socket or both.
+type Admin struct {
+	addr       string
+	socket     string
+	mux        *http.ServeMux
+	httpServer *http.Server
+
+	// Cancelled on shutdown to end long-lived streams, which a graceful
+	// shutdown would otherwise wait on
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func NewAdmin(addr string, socket string) *Admin {
+	mux := http.NewServeMux()
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Admin{
+		addr:   addr,
+		socket: socket,
+		mux:    mux,
+		ctx:    ctx,
+		cancel: cancel,
+		httpServer: &http.Server{
+			Handler:           mux,
+			ReadHeaderTimeout: 5 * time.Second,
+			IdleTimeout:       60 * time.Second,
+			BaseContext:       func(net.Listener) context.Context { return ctx },
+			ConnContext: func(ctx context.Context, c net.Conn) context.Context {
+				if c.LocalAddr().Network() == "unix" {
+					return context.WithValue(ctx, socketConn{}, true)
+				}
+				return ctx
+			},
+		},
+	}
+}
+
+// socketConn marks requests that came in over the Unix socket
+type socketConn struct{}
+
+// RequireAuth puts the admin endpoints behind HTTP basic authentication.
+// The socket is left open, its file mode already limits it to the user
+// running the server.
+func (a *Admin) RequireAuth(username string, password string) {
+	a.httpServer.Handler = basicAuth(a.mux, username, password)
+}
+
+func basicAuth(next http.Handler, username string, password string) http.Handler {
+	// Hashes compare in constant time whatever the length of the input
+	wantUser := sha256.Sum256([]byte(username))
+	wantPass := sha256.Sum256([]byte(password))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Context().Value(socketConn{}) != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if user, pass, ok := r.BasicAuth(); ok {
+			gotUser := sha256.Sum256([]byte(user))
+			gotPass := sha256.Sum256([]byte(pass))
+			if subtle.ConstantTimeCompare(gotUser[:], wantUser[:])&subtle.ConstantTimeCompare(gotPass[:], wantPass[:]) == 1 {
+				next.ServeHTTP(w, r)
+				return
+			}
+			slog.Warn("Admin authentication failed", "user", user, "remote_addr", r.RemoteAddr, "path", r.URL.Path)
+		}
+
+		w.Header().Set("WWW-Authenticate", `Basic realm="gogogo admin", charset="UTF-8"`)
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+	})
+}
+
+// Mux is where admin endpoints are registered
+func (a *Admin) Mux() *http.ServeMux {
+	return a.mux
+}
+
+// Addr describes where the admin endpoints are served, for logging
+func (a *Admin) Addr() string {
+	switch {
+	case a.socket == "":
+		return a.addr
+	case a.addr == "":
+		return "unix:" + a.socket
+	}
+	return a.addr + ", unix:" + a.socket
+}
+
+func (a *Admin) Start() error {
+	var listeners []net.Listener
+	closeAll := func() {
+		for _, ln := range listeners {
+			ln.Close()
+		}
+	}
+
+	if a.addr != "" {
+		ln, err := net.Listen("tcp", a.addr)
+		if err != nil {
+			return fmt.Errorf("failed to create admin listener: %w", err)
+		}
+		listeners = append(listeners, ln)
+	}
+
+	if a.socket != "" {
+		// A socket left behind by an unclean exit blocks the listen, anything
+		// else at the path is left alone
+		if info, err := os.Lstat(a.socket); err == nil {
+			if info.Mode().Type() != os.ModeSocket {
+				closeAll()
+				return fmt.Errorf("admin socket path %s exists and is not a socket", a.socket)
+			}
+			if err := os.Remove(a.socket); err != nil {
+				closeAll()
+				return fmt.Errorf("failed to remove stale admin socket: %w", err)
+			}
+		} else if !errors.Is(err, os.ErrNotExist) {
+			closeAll()
+			return fmt.Errorf("failed to check admin socket: %w", err)
+		}
+		ln, err := net.Listen("unix", a.socket)
+		if err != nil {
+			closeAll()
+			return fmt.Errorf("failed to create admin socket: %w", err)
+		}
+		// Only the user running the server may connect
+		if err := os.Chmod(a.socket, 0600); err != nil {
+			ln.Close()
+			closeAll()
+			return fmt.Errorf("failed to restrict admin socket: %w", err)
+		}
+		listeners = append(listeners, ln)
+	}
+
+	if len(listeners) == 0 {
+		return errors.New("admin listener has neither an address nor a socket")
+	}
+
+	errs := make(chan error, len(listeners))
+	for _, ln := range listeners {
+		go func(ln net.Listener) {
+			errs <- a.httpServer.Serve(ln)
+		}(ln)
+	}
+	return <-errs
+}
+
+func (a *Admin) Shutdown(ctx context.Context) error {
+	a.cancel()
+	return a.httpServer.Shutdown(ctx)
+}
@@ -0,0 +1,229 @@
+package server
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"strings"
+
+	"gogogo/modules/compression"
+)
+
+// CompressOptions configures response compression
+type CompressOptions struct {
+	MinSize int      // Smaller bodies are sent as is
+	Types   []string // Compressed MIME types, "text/*" style wildcards allowed
+}
+
+// Compress encodes responses on the fly with the best coding the client
+// accepts. Bodies are buffered up to MinSize to decide, shorter ones and
+// responses already encoded, such as precompressed files, pass through, as do
+// range requests whose byte offsets refer to the unencoded file. Compressible
+// responses vary on Accept-Encoding whether or not this one was encoded, so
+// shared caches keep the variants apart.
+func Compress(next http.Handler, opts CompressOptions) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead || r.Header.Get("Range") != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// Without an accepted coding the body is sent as is
+		encoding := compression.Negotiate(r.Header.Get("Accept-Encoding"), supportedEncoding)
+
+		cw := &compressWriter{
+			ResponseWriter: w,
+			encoding:       encoding,
+			opts:           &opts,
+			status:         http.StatusOK,
+		}
+		next.ServeHTTP(cw, r)
+
+		// Not deferred, a panicking handler must leave the header unsent
+		// for the recovery middleware
+		cw.Close()
+	})
+}
+
+func supportedEncoding(string) bool {
+	return true
+}
+
+// compressWriter holds back the header until the first MinSize bytes show
+// whether the response is worth compressing
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string // Empty when the client accepts none
+	opts     *CompressOptions
+
+	status      int
+	wroteHeader bool // WriteHeader was called by the handler
+	decided     bool // The header went out, with or without encoding
+	buf         []byte
+	writer      compression.Writer
+}
+
+func (cw *compressWriter) WriteHeader(status int) {
+	if cw.wroteHeader || cw.decided {
+		return
+	}
+
+	// Informational responses go out right away
+	if status >= 100 && status < 200 && status != http.StatusSwitchingProtocols {
+		cw.ResponseWriter.WriteHeader(status)
+		return
+	}
+
+	cw.wroteHeader = true
+	cw.status = status
+}
+
+func (cw *compressWriter) Write(p []byte) (int, error) {
+	if !cw.decided {
+		if len(cw.buf)+len(p) < cw.opts.MinSize {
+			cw.buf = append(cw.buf, p...)
+			return len(p), nil
+		}
+		if err := cw.decide(append(cw.buf, p...)); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
+
+	if cw.writer != nil {
+		return cw.writer.Write(p)
+	}
+	return cw.ResponseWriter.Write(p)
+}
+
+// decide sends the header, choosing whether to encode the body that starts
+// with the given bytes, and writes them
+func (cw *compressWriter) decide(body []byte) error {
+	cw.decided = true
+	cw.buf = nil
+
+	header := cw.ResponseWriter.Header()
+	if len(body) > 0 && header.Get("Content-Type") == "" {
+		header.Set("Content-Type", http.DetectContentType(body))
+	}
+
+	compressible := len(body) > 0 && cw.compressible(header)
+	if compressible {
+		addVary(header, "Accept-Encoding")
+	}
+
+	if compressible && cw.encoding != "" && len(body) >= cw.opts.MinSize {
+		header.Del("Content-Length")
+		header.Set("Content-Encoding", cw.encoding)
+
+		// The encoded body is no longer byte for byte what a strong ETag
+		// promised, a weak one still validates
+		if etag := header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			header.Set("ETag", "W/"+etag)
+		}
+
+		cw.writer = compression.NewWriter(cw.encoding, cw.ResponseWriter)
+	}
+
+	cw.ResponseWriter.WriteHeader(cw.status)
+	if len(body) == 0 {
+		return nil
+	}
+
+	var err error
+	if cw.writer != nil {
+		_, err = cw.writer.Write(body)
+	} else {
+		_, err = cw.ResponseWriter.Write(body)
+	}
+	return err
+}
+
+func (cw *compressWriter) compressible(header http.Header) bool {
+	switch cw.status {
+	case http.StatusNoContent, http.StatusPartialContent, http.StatusNotModified:
+		return false
+	}
+	if header.Get("Content-Encoding") != "" || header.Get("Content-Range") != "" {
+		return false
+	}
+	if strings.Contains(header.Get("Cache-Control"), "no-transform") {
+		return false
+	}
+
+	contentType, _, _ := strings.Cut(header.Get("Content-Type"), ";")
+	contentType = strings.TrimSpace(strings.ToLower(contentType))
+	if contentType == "text/event-stream" {
+		return false
+	}
+	if len(cw.opts.Types) == 0 {
+		return compression.Compressible(contentType)
+	}
+	for _, allowed := range cw.opts.Types {
+		if allowed == contentType {
+			return true
+		}
+		if prefix, ok := strings.CutSuffix(allowed, "*"); ok && strings.HasPrefix(contentType, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// addVary adds name to the Vary header unless a handler listed it already
+func addVary(header http.Header, name string) {
+	for _, value := range header.Values("Vary") {
+		for _, field := range strings.Split(value, ",") {
+			if field = strings.TrimSpace(field); field == "*" || strings.EqualFold(field, name) {
+				return
+			}
+		}
+	}
+	header.Add("Vary", name)
+}
+
+// Close writes out a body shorter than MinSize, or ends the encoded stream
+func (cw *compressWriter) Close() error {
+	if !cw.decided {
+		if !cw.wroteHeader && len(cw.buf) == 0 {
+			return nil
+		}
+		if err := cw.decide(cw.buf); err != nil {
+			return err
+		}
+	}
+
+	if cw.writer == nil {
+		return nil
+	}
+	err := cw.writer.Close()
+	compression.Release(cw.encoding, cw.writer)
+	cw.writer = nil
+	return err
+}
+
+// Flush sends what was written so far, streams decide on what they have
+func (cw *compressWriter) Flush() {
+	if !cw.decided {
+		cw.decide(cw.buf)
+	}
+	if cw.writer != nil {
+		cw.writer.Flush()
+	}
+	http.NewResponseController(cw.ResponseWriter).Flush()
+}
+
+func (cw *compressWriter) Push(target string, opts *http.PushOptions) error {
+	if pusher, ok := cw.ResponseWriter.(http.Pusher); ok {
+		return pusher.Push(target, opts)
+	}
+	return http.ErrNotSupported
+}
+
+func (cw *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return http.NewResponseController(cw.ResponseWriter).Hijack()
+}
+
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
@@ -3,7 +3,7 @@
 package server
 
 import (
-	"log"
+	"log/slog"
 	"net"
 	"syscall"
 	"time"
@@ -42,7 +42,7 @@ func (ln *tcpKeepAliveListener) Accept() (net.Conn, error) {
 	// Enable TCP Fast Open
 	if err := enableTCPFastOpen(fd); err != nil {
 		// Log error but don't fail - TFO is an optimization
-		log.Printf("TCP Fast Open enable failed: %v", err)
+		slog.Debug("TCP Fast Open enable failed", "error", err)
 	}
 
 	if err := tc.SetKeepAlive(true); err != nil {
@@ -0,0 +1,263 @@
+package server
+
+import (
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+const (
+	LiveReloadPath = "/__livereload"
+
+	liveReloadDebounce  = 100 * time.Millisecond
+	liveReloadKeepAlive = 15 * time.Second
+)
+
+// LiveReload streams change notifications to development browsers over
+// Server-Sent Events. A "css" event carries the URL path of a changed
+// stylesheet so it can be swapped in place, anything else sends "reload".
+type LiveReload struct {
+	mu      sync.Mutex
+	clients map[chan reloadEvent]struct{}
+	watcher *fsnotify.Watcher
+	root    string
+	pending map[string]struct{}
+	timer   *time.Timer
+}
+
+type reloadEvent struct {
+	name string
+	data string
+}
+
+func NewLiveReload() *LiveReload {
+	return &LiveReload{
+		clients: make(map[chan reloadEvent]struct{}),
+		pending: make(map[string]struct{}),
+	}
+}
+
+func (lr *LiveReload) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	// The stream outlives the server write timeout
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	events := make(chan reloadEvent, 16)
+	lr.mu.Lock()
+	lr.clients[events] = struct{}{}
+	lr.mu.Unlock()
+
+	defer func() {
+		lr.mu.Lock()
+		delete(lr.clients, events)
+		lr.mu.Unlock()
+	}()
+
+	fmt.Fprint(w, "retry: 1000\n\n")
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(liveReloadKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case ev := <-events:
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.name, ev.data)
+			flusher.Flush()
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": ping\n\n")
+			flusher.Flush()
+		}
+	}
+}
+
+// Broadcast sends an event to every connected browser, dropping it for
+// clients that are too slow to keep up
+func (lr *LiveReload) Broadcast(name, data string) {
+	lr.mu.Lock()
+	defer lr.mu.Unlock()
+
+	for client := range lr.clients {
+		select {
+		case client <- reloadEvent{name: name, data: data}:
+		default:
+		}
+	}
+}
+
+// Reload tells every connected browser to reload the current page
+func (lr *LiveReload) Reload() {
+	lr.Broadcast("reload", "")
+}
+
+// Watch recursively watches dirs below root and broadcasts changes. URL paths
+// sent with css events are relative to root, matching how the development
+// server maps requests onto the web directory.
+func (lr *LiveReload) Watch(root string, dirs ...string) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("failed to create live reload watcher: %w", err)
+	}
+
+	for _, dir := range dirs {
+		err := filepath.WalkDir(filepath.Join(root, dir), func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() {
+				return watcher.Add(path)
+			}
+			return nil
+		})
+		if err != nil && !os.IsNotExist(err) {
+			watcher.Close()
+			return fmt.Errorf("failed to watch %s: %w", dir, err)
+		}
+	}
+
+	lr.mu.Lock()
+	lr.watcher = watcher
+	lr.root = root
+	lr.mu.Unlock()
+
+	go lr.watch(watcher)
+
+	return nil
+}
+
+// Close stops watching for changes
+func (lr *LiveReload) Close() error {
+	lr.mu.Lock()
+	defer lr.mu.Unlock()
+
+	if lr.watcher == nil {
+		return nil
+	}
+	err := lr.watcher.Close()
+	lr.watcher = nil
+	return err
+}
+
+func (lr *LiveReload) watch(watcher *fsnotify.Watcher) {
+	for {
+		select {
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			if event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Remove|fsnotify.Rename) == 0 {
+				continue
+			}
+
+			if event.Op&fsnotify.Create != 0 {
+				if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+					watcher.Add(event.Name)
+				}
+			}
+
+			lr.queue(event.Name)
+
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			slog.Error("Live reload watcher error", "error", err)
+		}
+	}
+}
+
+// queue collects changes for the debounce window, editors often write a file
+// several times per save
+func (lr *LiveReload) queue(path string) {
+	lr.mu.Lock()
+	defer lr.mu.Unlock()
+
+	lr.pending[path] = struct{}{}
+	if lr.timer == nil {
+		lr.timer = time.AfterFunc(liveReloadDebounce, lr.flush)
+	} else {
+		lr.timer.Reset(liveReloadDebounce)
+	}
+}
+
+func (lr *LiveReload) flush() {
+	lr.mu.Lock()
+	changed := lr.pending
+	lr.pending = make(map[string]struct{})
+	root := lr.root
+	lr.mu.Unlock()
+
+	stylesheets := make([]string, 0, len(changed))
+	for path := range changed {
+		if filepath.Ext(path) != ".css" {
+			lr.Reload()
+			return
+		}
+
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			lr.Reload()
+			return
+		}
+		stylesheets = append(stylesheets, "/"+filepath.ToSlash(rel))
+	}
+
+	for _, url := range stylesheets {
+		lr.Broadcast("css", url)
+	}
+}
+
+// InjectLiveReload adds the live reload client to an HTML page, right before
+// the closing body tag when there is one
+func InjectLiveReload(page []byte) []byte {
+	idx := strings.LastIndex(strings.ToLower(string(page)), "</body>")
+	if idx == -1 {
+		return append(page, liveReloadClient...)
+	}
+
+	out := make([]byte, 0, len(page)+len(liveReloadClient))
+	out = append(out, page[:idx]...)
+	out = append(out, liveReloadClient...)
+	return append(out, page[idx:]...)
+}
+
+const liveReloadClient = `<script>
+(() => {
+	const source = new EventSource("` + LiveReloadPath + `");
+	source.addEventListener("reload", () => location.reload());
+	source.addEventListener("css", (e) => {
+		let swapped = false;
+		document.querySelectorAll('link[rel="stylesheet"]').forEach((link) => {
+			const url = new URL(link.href, location.href);
+			if (url.origin !== location.origin || url.pathname !== e.data) return;
+			url.searchParams.set("livereload", Date.now());
+			const next = link.cloneNode();
+			next.href = url.href;
+			next.onload = () => link.remove();
+			link.after(next);
+			swapped = true;
+		});
+		if (!swapped) location.reload();
+	});
+})();
+</script>
+`
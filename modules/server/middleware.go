@@ -0,0 +1,150 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+
+	"gogogo/middleware"
+	"gogogo/middleware/metrics"
+	"gogogo/modules/config"
+	"gogogo/modules/logger"
+)
+
+// Middleware wraps a handler with cross-cutting behaviour
+type Middleware func(http.Handler) http.Handler
+
+// Route groups middleware can be configured for, global middleware is
+// labelled as its own group
+const (
+	GroupGlobal = "global"
+	GroupWeb    = "web"
+	GroupSPA    = "spa"
+	GroupStatic = "static"
+	GroupAPI    = "api"
+)
+
+// Chain composes middleware into one, the first wraps all others
+func Chain(mw ...Middleware) Middleware {
+	return func(next http.Handler) http.Handler {
+		for i := len(mw) - 1; i >= 0; i-- {
+			next = mw[i](next)
+		}
+		return next
+	}
+}
+
+// chainBuilder builds middleware chains from the configuration. Stateful
+// middleware, such as the access log, is created once and shared by the
+// chains naming it.
+type chainBuilder struct {
+	handlers  Handlers
+	cfg       config.Config
+	accessLog *middleware.AccessLog
+	closers   []io.Closer // Released in reverse on shutdown
+}
+
+// chains builds the global chain and the chain of each route group
+func (b *chainBuilder) chains() (Middleware, map[string]Middleware, error) {
+	global, err := b.chain(GroupGlobal, b.cfg.Middleware.Global)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	groups := make(map[string]Middleware, 4)
+	for _, group := range []string{GroupWeb, GroupSPA, GroupStatic, GroupAPI} {
+		if groups[group], err = b.chain(group, b.cfg.Middleware.Groups[group]); err != nil {
+			return nil, nil, fmt.Errorf("%s group: %w", group, err)
+		}
+	}
+	for group := range b.cfg.Middleware.Groups {
+		if _, ok := groups[group]; !ok {
+			return nil, nil, fmt.Errorf("unknown middleware group %q", group)
+		}
+	}
+	return global, groups, nil
+}
+
+// chain builds the chain of the named built-in middleware for group
+func (b *chainBuilder) chain(group string, names []string) (Middleware, error) {
+	mw := make([]Middleware, 0, len(names))
+	for _, name := range names {
+		m, err := b.builtin(group, name)
+		if err != nil {
+			return nil, err
+		}
+		mw = append(mw, m)
+	}
+	return Chain(mw...), nil
+}
+
+func (b *chainBuilder) builtin(group, name string) (Middleware, error) {
+	switch name {
+	case "tracing":
+		return middleware.Tracing(), nil
+	case "recovery":
+		return middleware.Recovery(b.handlers.Error), nil
+	case "request_id":
+		return middleware.RequestIDs(), nil
+	case "access_log":
+		if b.accessLog == nil {
+			if err := b.openAccessLog(); err != nil {
+				return nil, err
+			}
+		}
+		return b.accessLog.Middleware(), nil
+	case "logging":
+		return middleware.Logging(), nil
+	case "metrics":
+		return metrics.MetricsMiddleware(group), nil
+	case "timeout":
+		return middleware.Timeout(b.cfg.Middleware.Timeout), nil
+	case "security_headers":
+		return middleware.SecurityHeaders(b.cfg.Middleware.SecurityHeaders), nil
+	}
+	return nil, fmt.Errorf("unknown middleware %q", name)
+}
+
+// openAccessLog starts the access log, its file rotated like the server log
+func (b *chainBuilder) openAccessLog() error {
+	cfg := b.cfg.AccessLog
+
+	var out io.Writer = os.Stdout
+	if cfg.File != "" {
+		rotation := b.cfg.Logging
+		file, err := logger.OpenRotating(cfg.File, rotation.MaxSize, rotation.RotateEvery, rotation.MaxBackups, rotation.MaxAge)
+		if err != nil {
+			return fmt.Errorf("failed to open access log: %w", err)
+		}
+		out = file
+		b.closers = append(b.closers, file)
+	}
+
+	accessLog, err := middleware.NewAccessLog(out, middleware.AccessLogOptions{
+		Format:        cfg.Format,
+		Buffer:        cfg.Buffer,
+		FlushInterval: cfg.FlushInterval,
+		Sample:        cfg.Sample,
+		Exclude:       cfg.Exclude,
+	})
+	if err != nil {
+		return err
+	}
+	b.accessLog = accessLog
+	b.closers = append(b.closers, accessLog)
+	return nil
+}
+
+// Close releases what the chains hold, the last opened first
+func (b *chainBuilder) Close() error {
+	var errs []error
+	for i := len(b.closers) - 1; i >= 0; i-- {
+		if err := b.closers[i].Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	b.closers = nil
+	return errors.Join(errs...)
+}
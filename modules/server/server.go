@@ -3,6 +3,7 @@ package server
 import (
 	"context"
 	"crypto/tls"
+	"errors"
 	"fmt"
 	"gogogo/modules/config"
 	"net"
@@ -17,6 +18,7 @@ type Server struct {
 	httpServer *http.Server
 	listener   net.Listener
 	config     *Config
+	middleware *chainBuilder
 }
 
 type Config struct {
@@ -32,13 +34,17 @@ type Config struct {
 }
 
 type Handlers struct {
-	Web    http.Handler
-	SPA    http.Handler
-	Static http.Handler
-	API    http.Handler
+	Web        http.Handler
+	SPA        http.Handler
+	Static     http.Handler
+	API        http.Handler
+	LiveReload http.Handler // Development only
+
+	// Error answers requests that failed with status, used on panics
+	Error func(w http.ResponseWriter, r *http.Request, status int)
 }
 
-func New(handlers Handlers, cfg config.Config) *Server {
+func New(handlers Handlers, cfg config.Config) (*Server, error) {
 	opts := &Config{
 		Host:           cfg.Server.Host,
 		Port:           cfg.Server.Port,
@@ -51,29 +57,49 @@ func New(handlers Handlers, cfg config.Config) *Server {
 		TCPKeepAlive:   30 * time.Second,
 	}
 
+	builder := &chainBuilder{handlers: handlers, cfg: cfg}
+	global, groups, err := builder.chains()
+	if err != nil {
+		builder.Close()
+		return nil, err
+	}
+
 	mux := http.NewServeMux()
 
 	// API routes
-	mux.Handle("/api/", handlers.API)
+	mux.Handle("/api/", groups[GroupAPI](handlers.API))
 
 	// SPA routes
 	if handlers.SPA != nil {
-		mux.Handle(cfg.URLPrefixes.SPA, http.StripPrefix(cfg.URLPrefixes.SPA, handlers.SPA))
+		mux.Handle(cfg.URLPrefixes.SPA, groups[GroupSPA](http.StripPrefix(cfg.URLPrefixes.SPA, handlers.SPA)))
 	}
 
 	// Static files
-	mux.Handle("/static/", handlers.Static)
+	mux.Handle("/static/", groups[GroupStatic](handlers.Static))
+
+	// Live reload event stream
+	if handlers.LiveReload != nil {
+		mux.Handle(LiveReloadPath, handlers.LiveReload)
+	}
 
 	// All other paths go to web handler
-	mux.Handle("/", handlers.Web)
+	mux.Handle("/", groups[GroupWeb](handlers.Web))
 
 	var handler http.Handler = mux
+	if cfg.Compression.Enabled {
+		handler = Compress(handler, CompressOptions{
+			MinSize: cfg.Compression.MinSize,
+			Types:   cfg.Compression.Types,
+		})
+	}
+	handler = global(handler)
 	if opts.EnableHTTP2 && opts.TLSConfig == nil {
-		handler = h2c.NewHandler(mux, &http2.Server{})
+		handler = h2c.NewHandler(handler, &http2.Server{})
 	}
 
 	return &Server{
-		config: opts,
+		config:     opts,
+		middleware: builder,
 		httpServer: &http.Server{
 			Handler:           handler,
 			ReadTimeout:       opts.ReadTimeout,
@@ -82,7 +108,7 @@ func New(handlers Handlers, cfg config.Config) *Server {
 			MaxHeaderBytes:    opts.MaxHeaderBytes,
 			ReadHeaderTimeout: opts.ReadTimeout,
 		},
-	}
+	}, nil
 }
 
 func (s *Server) Start() error {
@@ -111,8 +137,11 @@ func (s *Server) Start() error {
 	return s.httpServer.Serve(ln)
 }
 
+// Shutdown stops the server gracefully, then flushes and closes what the
+// middleware holds, such as the access log
 func (s *Server) Shutdown(ctx context.Context) error {
-	return s.httpServer.Shutdown(ctx)
+	err := s.httpServer.Shutdown(ctx)
+	return errors.Join(err, s.middleware.Close())
 }
 
 // type tcpKeepAliveListener struct {
@@ -0,0 +1,255 @@
+package templates
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"path"
+	"reflect"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"gogogo/modules/metaparser"
+)
+
+const staticPrefix = "/static/"
+
+// builtinFuncs is the function library available to every template. Helpers
+// take the piped value as their last argument, e.g. {{.Title | truncate 40}}.
+func (t *TemplateEngine) builtinFuncs() template.FuncMap {
+	return template.FuncMap{
+		// Dates
+		"now":        time.Now,
+		"formatDate": formatDate,
+
+		// URLs and assets
+		"asset": t.asset,
+
+		// Trusted content
+		"safeHTML": safeHTML,
+		"safeCSS":  safeCSS,
+		"safeJS":   safeJS,
+		"safeURL":  safeURL,
+
+		// Data
+		"dict":     dict,
+		"list":     list,
+		"default":  defaultValue,
+		"variable": variable,
+		"json":     toJSON,
+
+		// Strings
+		"upper":     strings.ToUpper,
+		"lower":     strings.ToLower,
+		"title":     title,
+		"trim":      strings.TrimSpace,
+		"replace":   replace,
+		"contains":  contains,
+		"hasPrefix": hasPrefix,
+		"hasSuffix": hasSuffix,
+		"split":     split,
+		"join":      join,
+		"slugify":   slugify,
+		"truncate":  truncate,
+	}
+}
+
+// Funcs registers additional template functions, replacing built-ins with the
+// same name. Already parsed templates are dropped so they pick up the change.
+func (t *TemplateEngine) Funcs(funcs template.FuncMap) {
+	t.templateMutex.Lock()
+	defer t.templateMutex.Unlock()
+
+	merged := make(template.FuncMap, len(t.funcs)+len(funcs))
+	for name, fn := range t.funcs {
+		merged[name] = fn
+	}
+	for name, fn := range funcs {
+		merged[name] = fn
+	}
+
+	t.funcs = merged
+	t.templates = make(map[string]*template.Template)
+}
+
+// asset returns the URL of a file in the static directory, fingerprinted
+// when the build recorded it in the asset manifest and the hashed file is
+// served, which in development it usually is not
+func (t *TemplateEngine) asset(p string) string {
+	if strings.Contains(p, "://") {
+		return p
+	}
+	url := staticPrefix + strings.TrimPrefix(path.Clean("/"+p), "/")
+
+	t.templateMutex.RLock()
+	manifest := t.assets
+	t.templateMutex.RUnlock()
+
+	if manifest != nil {
+		if hashed, ok := manifest.Lookup(strings.TrimPrefix(url, "/")); ok && t.fm.Exists(hashed) {
+			return hashed
+		}
+	}
+	return url
+}
+
+// formatDate formats a time.Time, RFC 3339 / YYYY-MM-DD string or unix
+// timestamp using a Go reference layout
+func formatDate(layout string, v interface{}) (string, error) {
+	switch d := v.(type) {
+	case time.Time:
+		return d.Format(layout), nil
+	case *time.Time:
+		if d == nil {
+			return "", nil
+		}
+		return d.Format(layout), nil
+	case int64:
+		return time.Unix(d, 0).Format(layout), nil
+	case int:
+		return time.Unix(int64(d), 0).Format(layout), nil
+	case string:
+		for _, l := range []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02"} {
+			if parsed, err := time.Parse(l, d); err == nil {
+				return parsed.Format(layout), nil
+			}
+		}
+		return "", fmt.Errorf("formatDate: cannot parse %q", d)
+	case nil:
+		return "", nil
+	}
+	return "", fmt.Errorf("formatDate: unsupported type %T", v)
+}
+
+func safeHTML(v interface{}) template.HTML { return template.HTML(fmt.Sprint(v)) }
+func safeCSS(v interface{}) template.CSS   { return template.CSS(fmt.Sprint(v)) }
+func safeJS(v interface{}) template.JS     { return template.JS(fmt.Sprint(v)) }
+func safeURL(v interface{}) template.URL   { return template.URL(fmt.Sprint(v)) }
+
+// dict builds a map from key/value pairs, handy for passing several values
+// into a partial: {{template "card" dict "Title" .Meta.Title "Page" .}}
+func dict(pairs ...interface{}) (map[string]interface{}, error) {
+	if len(pairs)%2 != 0 {
+		return nil, errors.New("dict: odd number of arguments")
+	}
+
+	m := make(map[string]interface{}, len(pairs)/2)
+	for i := 0; i < len(pairs); i += 2 {
+		key, ok := pairs[i].(string)
+		if !ok {
+			return nil, fmt.Errorf("dict: key %v is not a string", pairs[i])
+		}
+		m[key] = pairs[i+1]
+	}
+	return m, nil
+}
+
+func list(values ...interface{}) []interface{} {
+	return values
+}
+
+// defaultValue returns def when v is nil, false, zero or empty
+func defaultValue(def interface{}, v interface{}) interface{} {
+	if isEmpty(v) {
+		return def
+	}
+	return v
+}
+
+func isEmpty(v interface{}) bool {
+	if v == nil {
+		return true
+	}
+
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return rv.Len() == 0
+	case reflect.Ptr, reflect.Interface:
+		return rv.IsNil()
+	}
+	return rv.IsZero()
+}
+
+// variable reads a page variable without failing on missing meta or keys
+func variable(key string, meta *metaparser.MetaData) interface{} {
+	if meta == nil || meta.Variables == nil {
+		return nil
+	}
+	return meta.Variables[key]
+}
+
+func toJSON(v interface{}) (template.JS, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", fmt.Errorf("json: %w", err)
+	}
+	return template.JS(b), nil
+}
+
+func title(s string) string {
+	prev := ' '
+	return strings.Map(func(r rune) rune {
+		if unicode.IsSpace(prev) || prev == '-' || prev == '_' {
+			prev = r
+			return unicode.ToTitle(r)
+		}
+		prev = r
+		return r
+	}, s)
+}
+
+func replace(old, new, s string) string { return strings.ReplaceAll(s, old, new) }
+func contains(substr, s string) bool    { return strings.Contains(s, substr) }
+func hasPrefix(prefix, s string) bool   { return strings.HasPrefix(s, prefix) }
+func hasSuffix(suffix, s string) bool   { return strings.HasSuffix(s, suffix) }
+func split(sep, s string) []string      { return strings.Split(s, sep) }
+
+func join(sep string, v interface{}) (string, error) {
+	switch items := v.(type) {
+	case []string:
+		return strings.Join(items, sep), nil
+	case []interface{}:
+		parts := make([]string, len(items))
+		for i, item := range items {
+			parts[i] = fmt.Sprint(item)
+		}
+		return strings.Join(parts, sep), nil
+	}
+	return "", fmt.Errorf("join: unsupported type %T", v)
+}
+
+// slugify lowercases s and collapses every run of non-alphanumerics into a dash
+func slugify(s string) string {
+	var b strings.Builder
+	b.Grow(len(s))
+
+	dash := false
+	for _, r := range strings.ToLower(s) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+			dash = false
+		} else if !dash && b.Len() > 0 {
+			b.WriteByte('-')
+			dash = true
+		}
+	}
+
+	return strings.TrimSuffix(b.String(), "-")
+}
+
+// truncate shortens s to at most n runes, ending with an ellipsis when cut
+func truncate(n int, s string) string {
+	if n <= 0 {
+		return ""
+	}
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+
+	runes := []rune(s)
+	return strings.TrimRightFunc(string(runes[:n]), unicode.IsSpace) + "…"
+}
@@ -0,0 +1,48 @@
+package templates
+
+import (
+	"bytes"
+	"html/template"
+	"log/slog"
+)
+
+var overlayTemplate = template.Must(template.New("overlay").Parse(`<!doctype html>
+<html lang="en">
+<head>
+<meta charset="UTF-8" />
+<title>{{.Title}}</title>
+<style>
+	body { margin: 0; background: #1d1f21; color: #e8e8e8; font: 14px/1.5 ui-monospace, SFMono-Regular, Menlo, monospace; }
+	.overlay { max-width: 960px; margin: 8vh auto; padding: 24px 32px; border-top: 4px solid #e5534b; background: #26282b; box-shadow: 0 8px 32px rgba(0, 0, 0, .5); }
+	h1 { margin: 0 0 16px; font-size: 18px; color: #ff7b72; }
+	pre { margin: 0; white-space: pre-wrap; word-break: break-word; }
+	p { margin: 16px 0 0; color: #8b949e; }
+</style>
+</head>
+<body>
+<div class="overlay">
+	<h1>{{.Title}}</h1>
+	<pre>{{.Error}}</pre>
+	<p>Fix the file and save, the page reloads with the new template.</p>
+</div>
+</body>
+</html>
+`))
+
+// ErrorOverlay renders err as a full page so template mistakes show up in the
+// browser during development instead of as a blank or stale response
+func ErrorOverlay(title string, err error) []byte {
+	data := struct {
+		Title string
+		Error string
+	}{
+		Title: title,
+		Error: err.Error(),
+	}
+
+	var buf bytes.Buffer
+	if err := overlayTemplate.Execute(&buf, data); err != nil {
+		slog.Error("Error rendering overlay", "error", err)
+	}
+	return buf.Bytes()
+}
@@ -1,20 +1,55 @@
 package templates
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"html/template"
 	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
 	"sync"
 
+	"gogogo/modules/assets"
 	"gogogo/modules/filemanager"
+	"gogogo/modules/tracing"
+
+	"github.com/fsnotify/fsnotify"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	layoutFile  = "index.html"
+	partialsDir = "partials"
 )
 
+var (
+	ErrTemplateNotFound = errors.New("template not found")
+)
+
+var tracer = tracing.Tracer("gogogo/modules/templates")
+
+// extendsPattern matches a leading {{/* extends "name" */}} directive
+var extendsPattern = regexp.MustCompile(`^\s*\{\{-?\s*/\*\s*extends\s+"([^"]+)"\s*\*/\s*-?\}\}`)
+
+type layout struct {
+	name    string
+	content string
+}
+
 type TemplateEngine struct {
 	templates     map[string]*template.Template
 	templateMutex sync.RWMutex
+	funcs         template.FuncMap
+	assets        *assets.Manifest // fingerprinted URLs for the asset helper
 	fm            *filemanager.FileManager
 	dir           string
-	GetTemplate   func(string) (*template.Template, error)
+	watcher       *fsnotify.Watcher
+	names         map[string]struct{} // templates requested while watching
+	GetTemplate   func(ctx context.Context, name string) (*template.Template, error)
+	OnReload      func() // Called after watched templates were re-parsed
 }
 
 func New(fm *filemanager.FileManager, dir string, productionMode bool) *TemplateEngine {
@@ -23,6 +58,7 @@ func New(fm *filemanager.FileManager, dir string, productionMode bool) *Template
 		fm:        fm,
 		dir:       dir,
 	}
+	t.funcs = t.builtinFuncs()
 
 	if productionMode {
 		t.GetTemplate = t.getProduction
@@ -33,7 +69,10 @@ func New(fm *filemanager.FileManager, dir string, productionMode bool) *Template
 	return t
 }
 
-func (t *TemplateEngine) getProduction(name string) (*template.Template, error) {
+func (t *TemplateEngine) getProduction(ctx context.Context, name string) (tmpl *template.Template, err error) {
+	ctx, span := tracer.Start(ctx, "template.Get", trace.WithAttributes(attribute.String("template.name", name)))
+	defer func() { tracing.End(span, err) }()
+
 	t.templateMutex.RLock()
 	tmpl, exists := t.templates[name]
 	t.templateMutex.RUnlock()
@@ -48,29 +87,140 @@ func (t *TemplateEngine) getProduction(name string) (*template.Template, error)
 	}
 
 	// Slow path - load and parse template
-	path := filepath.Join(t.dir, name, "index.html")
-	content, err := t.fm.GetContent(path)
+	tmpl, err = t.parse(ctx, name, t.funcs)
 	if err != nil {
-		return nil, fmt.Errorf("error reading template file: %w", err)
+		return nil, err
 	}
 
-	tmpl, err = template.New(filepath.Base(name)).Parse(string(content))
-	if err != nil {
-		return nil, fmt.Errorf("error parsing template: %w", err)
+	t.templates[name] = tmpl
+
+	return tmpl, nil
+}
+
+func (t *TemplateEngine) getDevelopment(ctx context.Context, name string) (tmpl *template.Template, err error) {
+	ctx, span := tracer.Start(ctx, "template.Get", trace.WithAttributes(attribute.String("template.name", name)))
+	defer func() { tracing.End(span, err) }()
+
+	t.templateMutex.RLock()
+	tmpl, exists := t.templates[name]
+	watching := t.watcher != nil
+	funcs := t.funcs
+	t.templateMutex.RUnlock()
+	if exists {
+		return tmpl, nil
 	}
 
-	// Store in sync.Map (handles race conditions internally)
-	t.templates[name] = tmpl
+	// Without a watcher nothing would invalidate the cache, parse every time
+	tmpl, err = t.parse(ctx, name, funcs)
+	if !watching {
+		return tmpl, err
+	}
+
+	t.templateMutex.Lock()
+	t.names[name] = struct{}{}
+	if err == nil {
+		t.templates[name] = tmpl
+	}
+	t.templateMutex.Unlock()
+
+	return tmpl, err
+}
+
+// parse builds a template from its layout, the layouts it extends and all
+// partials. The root layout is parsed first so that every child layout's
+// {{define}} blocks override the {{block}} defaults of its parent.
+func (t *TemplateEngine) parse(ctx context.Context, name string, funcs template.FuncMap) (_ *template.Template, err error) {
+	ctx, span := tracer.Start(ctx, "template.Parse", trace.WithAttributes(attribute.String("template.name", name)))
+	defer func() { tracing.End(span, err) }()
+
+	if name == "" {
+		return nil, fmt.Errorf("%w: empty template name", ErrTemplateNotFound)
+	}
+
+	chain := make([]layout, 0, 2)
+	seen := make(map[string]bool, 2)
+	for current := name; current != ""; {
+		if seen[current] {
+			return nil, fmt.Errorf("error parsing template %q: extends cycle at %q", name, current)
+		}
+		seen[current] = true
+
+		l, err := t.readLayout(ctx, current)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, l)
+
+		current = ""
+		if m := extendsPattern.FindStringSubmatch(l.content); m != nil {
+			current = m[1]
+		}
+	}
+
+	tmpl := template.New(filepath.Base(name)).Funcs(funcs)
+	if err := t.parsePartials(ctx, tmpl); err != nil {
+		return nil, err
+	}
+
+	for i := len(chain) - 1; i >= 0; i-- {
+		if _, err := tmpl.Parse(chain[i].content); err != nil {
+			return nil, fmt.Errorf("error parsing template %q: %w", chain[i].name, err)
+		}
+	}
 
 	return tmpl, nil
 }
 
-func (t *TemplateEngine) getDevelopment(name string) (*template.Template, error) {
-	path := filepath.Join(t.dir, name, "index.html")
-	content, err := t.fm.GetContent(path)
+func (t *TemplateEngine) readLayout(ctx context.Context, name string) (layout, error) {
+	path := filepath.Join(t.dir, name, layoutFile)
+	content, err := t.fm.GetContent(ctx, path)
 	if err != nil {
-		return nil, fmt.Errorf("error reading template file: %w", err)
+		return layout{}, fmt.Errorf("%w: %q (expected %s): %v", ErrTemplateNotFound, name, path, err)
 	}
 
-	return template.New(filepath.Base(name)).Parse(string(content))
+	return layout{name: name, content: string(content)}, nil
+}
+
+// parsePartials associates every file in the partials directory with tmpl,
+// each one callable by its file name without extension
+func (t *TemplateEngine) parsePartials(ctx context.Context, tmpl *template.Template) error {
+	dir := filepath.Join(t.dir, partialsDir)
+	names, err := t.fm.List(dir)
+	if err != nil {
+		if errors.Is(err, filemanager.ErrNotFound) {
+			return nil
+		}
+		return fmt.Errorf("error listing partials: %w", err)
+	}
+	sort.Strings(names)
+
+	for _, file := range names {
+		content, err := t.fm.GetContent(ctx, filepath.Join(dir, file))
+		if err != nil {
+			return fmt.Errorf("error reading partial %s: %w", file, err)
+		}
+
+		partial := strings.TrimSuffix(file, filepath.Ext(file))
+		if _, err := tmpl.New(partial).Parse(string(content)); err != nil {
+			return fmt.Errorf("error parsing partial %s: %w", file, err)
+		}
+	}
+
+	return nil
+}
+
+// Reset drops every cached template so the next request parses it again,
+// e.g. after the production router was swapped to a new build
+func (t *TemplateEngine) Reset() {
+	t.templateMutex.Lock()
+	t.templates = make(map[string]*template.Template)
+	t.templateMutex.Unlock()
+}
+
+// SetAssets makes the asset helper return the fingerprinted URLs recorded in
+// m, without one it returns plain /static/ URLs
+func (t *TemplateEngine) SetAssets(m *assets.Manifest) {
+	t.templateMutex.Lock()
+	t.assets = m
+	t.templateMutex.Unlock()
 }
@@ -0,0 +1,128 @@
+package templates
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+const reloadDebounce = 100 * time.Millisecond
+
+// Watch caches parsed templates and re-parses them whenever a file below
+// root changes, so the development server picks up layout and partial edits
+// without a restart.
+func (t *TemplateEngine) Watch(root string) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("failed to create template watcher: %w", err)
+	}
+
+	if err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return watcher.Add(path)
+		}
+		return nil
+	}); err != nil {
+		watcher.Close()
+		return fmt.Errorf("failed to watch templates: %w", err)
+	}
+
+	t.templateMutex.Lock()
+	t.watcher = watcher
+	t.names = make(map[string]struct{})
+	t.templateMutex.Unlock()
+
+	go t.watch(watcher)
+
+	return nil
+}
+
+// Close stops watching the template directory
+func (t *TemplateEngine) Close() error {
+	t.templateMutex.Lock()
+	defer t.templateMutex.Unlock()
+
+	if t.watcher == nil {
+		return nil
+	}
+	err := t.watcher.Close()
+	t.watcher = nil
+	return err
+}
+
+func (t *TemplateEngine) watch(watcher *fsnotify.Watcher) {
+	var timer *time.Timer
+
+	for {
+		select {
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			if event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Remove|fsnotify.Rename) == 0 {
+				continue
+			}
+
+			// Pick up newly created directories, e.g. a new layout
+			if event.Op&fsnotify.Create != 0 {
+				if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+					watcher.Add(event.Name)
+				}
+			}
+
+			if timer == nil {
+				timer = time.AfterFunc(reloadDebounce, t.reload)
+			} else {
+				timer.Reset(reloadDebounce)
+			}
+
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			slog.Error("Template watcher error", "error", err)
+		}
+	}
+}
+
+// reload drops every cached template and re-parses the ones that have been
+// requested so far. Failed templates stay out of the cache, the next request
+// re-parses them and gets the error instead of stale output.
+func (t *TemplateEngine) reload() {
+	t.templateMutex.Lock()
+	names := make([]string, 0, len(t.names))
+	for name := range t.names {
+		names = append(names, name)
+	}
+	t.templates = make(map[string]*template.Template)
+	funcs := t.funcs
+	t.templateMutex.Unlock()
+
+	for _, name := range names {
+		tmpl, err := t.parse(context.Background(), name, funcs)
+		if err != nil {
+			slog.Error("Template failed to reload", "template", name, "error", err)
+			continue
+		}
+
+		t.templateMutex.Lock()
+		t.templates[name] = tmpl
+		t.templateMutex.Unlock()
+	}
+
+	slog.Info("Templates reloaded")
+
+	if t.OnReload != nil {
+		t.OnReload()
+	}
+}
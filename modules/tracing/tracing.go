@@ -0,0 +1,173 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type Config struct {
+	Exporter    string            // "otlp" or "stdout"
+	Endpoint    string            // OTLP/HTTP collector, host:port or a URL
+	Insecure    bool              // Plain HTTP to the collector
+	Headers     map[string]string // Sent with every export, e.g. for auth
+	File        string            // Where the stdout exporter writes, empty for stdout
+	SampleRatio float64           // Fraction of new traces recorded, 0 for all
+	ServiceName string
+}
+
+// Provider exports the spans of the process until shut down
+type Provider struct {
+	provider *sdktrace.TracerProvider
+	closer   io.Closer
+}
+
+// Setup makes a tracer provider as configured the global one and propagates
+// W3C trace context and baggage. Until it is called every span is a no-op.
+func Setup(ctx context.Context, cfg Config) (*Provider, error) {
+	exporter, closer, err := newExporter(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	serviceName := cfg.ServiceName
+	if serviceName == "" {
+		serviceName = "gogogo"
+	}
+	res, err := resource.New(ctx,
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+		resource.WithHost(),
+		resource.WithAttributes(semconv.ServiceName(serviceName)),
+	)
+	if err != nil {
+		closer.Close()
+		return nil, fmt.Errorf("failed to describe trace resource: %w", err)
+	}
+
+	// Requests carrying a sampled parent are always traced, so traces
+	// started upstream stay whole. An unset ratio records every trace.
+	sampleRatio := cfg.SampleRatio
+	if sampleRatio <= 0 {
+		sampleRatio = 1
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(sampleRatio))),
+	)
+
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+		slog.Warn("Tracing error", "error", err)
+	}))
+
+	return &Provider{provider: provider, closer: closer}, nil
+}
+
+func newExporter(ctx context.Context, cfg Config) (sdktrace.SpanExporter, io.Closer, error) {
+	switch strings.ToLower(cfg.Exporter) {
+	case "", "otlp":
+		opts := []otlptracehttp.Option{}
+		if strings.Contains(cfg.Endpoint, "://") {
+			opts = append(opts, otlptracehttp.WithEndpointURL(cfg.Endpoint))
+		} else if cfg.Endpoint != "" {
+			opts = append(opts, otlptracehttp.WithEndpoint(cfg.Endpoint))
+		}
+		if cfg.Insecure {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		}
+		if len(cfg.Headers) > 0 {
+			opts = append(opts, otlptracehttp.WithHeaders(cfg.Headers))
+		}
+
+		exporter, err := otlptracehttp.New(ctx, opts...)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to create OTLP exporter: %w", err)
+		}
+		return exporter, nopCloser{}, nil
+
+	case "stdout":
+		var out io.Writer = os.Stdout
+		var closer io.Closer = nopCloser{}
+		if cfg.File != "" {
+			file, err := os.OpenFile(cfg.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed to open trace file: %w", err)
+			}
+			out, closer = file, file
+		}
+
+		exporter, err := stdouttrace.New(stdouttrace.WithWriter(out))
+		if err != nil {
+			closer.Close()
+			return nil, nil, fmt.Errorf("failed to create stdout exporter: %w", err)
+		}
+		return exporter, closer, nil
+	}
+
+	return nil, nil, fmt.Errorf("unknown trace exporter %q", cfg.Exporter)
+}
+
+// Shutdown exports the spans still queued and stops the exporter
+func (p *Provider) Shutdown(ctx context.Context) error {
+	return errors.Join(p.provider.Shutdown(ctx), p.closer.Close())
+}
+
+// Tracer returns the tracer of a package, following the global provider
+// once Setup ran
+func Tracer(name string) trace.Tracer {
+	return otel.Tracer(name)
+}
+
+// End ends span, marking it failed if err is set
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+type serverKey struct{}
+
+type serverSpan struct {
+	span   trace.Span
+	method string
+}
+
+// WithServerSpan stores the server span of a request for Route to name
+func WithServerSpan(ctx context.Context, span trace.Span, method string) context.Context {
+	return context.WithValue(ctx, serverKey{}, serverSpan{span: span, method: method})
+}
+
+// Route names the server span of the request in ctx after the route that
+// matched, e.g. "GET /blog/:slug", rather than after the path requested,
+// which would make a name per page
+func Route(ctx context.Context, route string) {
+	s, ok := ctx.Value(serverKey{}).(serverSpan)
+	if !ok || !s.span.IsRecording() {
+		return
+	}
+	s.span.SetName(s.method + " " + route)
+	s.span.SetAttributes(semconv.HTTPRoute(route))
+}
+
+type nopCloser struct{}
+
+func (nopCloser) Close() error { return nil }
@@ -5,6 +5,8 @@ export class TransitionManager {
 		this.app = document.getElementById("app");
 		this.customTransitions = new Map();
 		this.transitionContext = {};
+		// Build the server reported, payloads requested with it are cached for good
+		this.buildID = null;
 	}
 
 	init() {
@@ -32,10 +34,16 @@ export class TransitionManager {
 
 	async loadContent(path) {
 		try {
-			const response = await fetch(`/__spa__${path}`);
-			if (!response.ok) throw new Error("Page not found");
+			const version = this.buildID ? `?v=${this.buildID}` : "";
+			const response = await fetch(`/__spa__${path}${version}`);
+			this.buildID = response.headers.get("X-Build-ID") || this.buildID;
 
-			const data = await response.json();
+			// Errors come as JSON too, with the error page content if any
+			const data = await response.json().catch(() => null);
+			if (!response.ok || !data) {
+				this.showError(response.status, data);
+				return;
+			}
 
 			if (document.startViewTransition) {
 				await document.startViewTransition(() => {
@@ -48,13 +56,25 @@ export class TransitionManager {
 			}
 		} catch (error) {
 			console.error("Error loading content:", error);
-			this.app.innerHTML = "<h1>404 - Page Not Found</h1>";
+			this.showError(0, null);
 		} finally {
 			// Clear the context after the transition
 			this.transitionContext = {};
 		}
 	}
 
+	showError(status, data) {
+		if (data && data.Content) {
+			this.updateDOM(data);
+			return;
+		}
+
+		const message = (data && data.Error && data.Error.Message) || "Page failed to load";
+		const heading = document.createElement("h1");
+		heading.textContent = status ? `${status} - ${message}` : message;
+		this.app.replaceChildren(heading);
+	}
+
 	runCustomTransitions(path) {
 		const transitionFunc = this.customTransitions.get(path);
 		if (transitionFunc) {